@@ -1,37 +1,315 @@
 package auth
 
-import "time"
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"gopkg.in/dgrijalva/jwt-go.v2"
+)
+
+// Errors returned from Authenticator
+var (
+	ErrCannotSign       = errors.New("Authenticator can not sign tokens")
+	ErrTokenTypeInvalid = errors.New("Token type is invalid")
+	ErrTokenRevoked     = errors.New("Token has been revoked")
+	ErrClaimMissing     = errors.New("Token claim is missing")
+	ErrClaimInvalid     = errors.New("Token claim is invalid")
+	ErrIssuerInvalid    = errors.New("Token issuer is invalid")
+	ErrAudienceInvalid  = errors.New("Token audience is invalid")
+	ErrClaimReserved    = errors.New("Claim name is reserved")
+	ErrLifetimeInvalid  = errors.New("Lifetime is invalid")
+
+	ErrRefreshClientInvalid = errors.New("Refresh token was issued to another client")
+	ErrTokenClientInvalid   = errors.New("Token was issued to another client")
+)
+
+// ClaimError is returned when a claim is missing, invalid or reserved, Err is
+// one of ErrClaimMissing, ErrClaimInvalid or ErrClaimReserved
+type ClaimError struct {
+	Claim string
+	Err   error
+}
+
+func (e *ClaimError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Err, e.Claim)
+}
+
+// Unwrap returns Err
+func (e *ClaimError) Unwrap() error {
+	return e.Err
+}
 
 // Authenticator is user authenticator and token generator
 type Authenticator struct {
-	generator       *TokenGenerator
-	lifetime        time.Duration
-	refreshLifetime time.Duration
-	storage         Storage
+	generator          *TokenGenerator
+	verifier           Verifier
+	lifetime           time.Duration
+	refreshLifetime    time.Duration
+	maxSessionLifetime time.Duration
+	slidingRefresh     bool
+	storage            ContextStorage
+	issuer             string
+	audience           []string
+	revocations        RevocationStore
+	families           FamilyStore
+	sessions           SessionStore
+	roles              *Roles
+}
+
+// AuthenticatorOption configures an Authenticator
+type AuthenticatorOption func(*Authenticator)
+
+// WithLifetime sets how long tokens are valid for, zero means tokens do not
+// expire
+func WithLifetime(lifetime time.Duration) AuthenticatorOption {
+	return func(a *Authenticator) {
+		a.lifetime = lifetime
+	}
+}
+
+// WithRefreshLifetime sets how long refresh tokens are valid for, zero means
+// refresh tokens do not expire
+func WithRefreshLifetime(lifetime time.Duration) AuthenticatorOption {
+	return func(a *Authenticator) {
+		a.refreshLifetime = lifetime
+	}
+}
+
+// WithMaxSessionLifetime sets how long after a user authenticates tokens can
+// be refreshed for, no token or refresh token expires after this. Zero means
+// sessions can be refreshed forever.
+func WithMaxSessionLifetime(lifetime time.Duration) AuthenticatorOption {
+	return func(a *Authenticator) {
+		a.maxSessionLifetime = lifetime
+	}
+}
+
+// WithSlidingRefresh sets if refreshing extends the refresh lifetime. If true,
+// the default, every refresh token is valid for the refresh lifetime from
+// when it is generated. If false, refresh tokens generated by a refresh
+// expire with the refresh token they replace.
+func WithSlidingRefresh(sliding bool) AuthenticatorOption {
+	return func(a *Authenticator) {
+		a.slidingRefresh = sliding
+	}
+}
+
+// WithIssuer sets the iss claim of generated tokens, validated tokens must
+// have the same issuer
+func WithIssuer(issuer string) AuthenticatorOption {
+	return func(a *Authenticator) {
+		a.issuer = issuer
+	}
+}
+
+// WithAudience sets the aud claim of generated tokens, validated tokens must
+// have at least one of the audiences
+func WithAudience(audience ...string) AuthenticatorOption {
+	return func(a *Authenticator) {
+		a.audience = audience
+	}
+}
+
+// WithRevocationStore sets the store used to revoke tokens, validated tokens
+// are checked against it
+func WithRevocationStore(store RevocationStore) AuthenticatorOption {
+	return func(a *Authenticator) {
+		a.revocations = store
+	}
+}
+
+// WithFamilyStore enables refresh token rotation, refresh tokens can only be
+// used once and reusing one revokes every refresh token in its family
+func WithFamilyStore(store FamilyStore) AuthenticatorOption {
+	return func(a *Authenticator) {
+		a.families = store
+	}
+}
+
+// WithContextStorage sets the account storage, it replaces the Storage passed
+// to the constructor
+func WithContextStorage(storage ContextStorage) AuthenticatorOption {
+	return func(a *Authenticator) {
+		a.storage = storage
+	}
+}
+
+// WithSessionStore enables sessions, every login creates a session and tokens
+// are only valid while their session is alive
+func WithSessionStore(store SessionStore) AuthenticatorOption {
+	return func(a *Authenticator) {
+		a.sessions = store
+	}
+}
+
+// WithRoles expands the roles claim of validated tokens into permissions, so
+// changes to the roles take effect without generating new tokens
+func WithRoles(roles *Roles) AuthenticatorOption {
+	return func(a *Authenticator) {
+		a.roles = roles
+	}
+}
+
+// New creates an Authenticator, the lifetimes are validated
+func New(generator *TokenGenerator, storage Storage, options ...AuthenticatorOption) (*Authenticator, error) {
+	a := &Authenticator{
+		generator:      generator,
+		verifier:       generator,
+		storage:        storageWithContext(storage),
+		slidingRefresh: true,
+	}
+
+	for _, option := range options {
+		option(a)
+	}
+
+	if err := a.validateLifetimes(); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// NewAuthenticator creates a Authenticator, lifetimes of zero or less mean
+// tokens do not expire. Use New to have the lifetimes validated.
+func NewAuthenticator(generator *TokenGenerator, storage Storage, lifetime time.Duration, refreshLifetime time.Duration, options ...AuthenticatorOption) *Authenticator {
+	a := &Authenticator{
+		generator:       generator,
+		verifier:        generator,
+		storage:         storageWithContext(storage),
+		lifetime:        lifetime,
+		refreshLifetime: refreshLifetime,
+		slidingRefresh:  true,
+	}
+
+	for _, option := range options {
+		option(a)
+	}
+
+	return a
 }
 
-// NewAuthenticator creates a Authenticator
-func NewAuthenticator(generator *TokenGenerator, storage Storage, lifetime time.Duration, refreshLifetime time.Duration) *Authenticator {
-	return &Authenticator{
-		generator: generator,
-		storage:   storage,
-		lifetime:  lifetime,
+// storageWithContext adapts storage, keeping nil storage nil
+func storageWithContext(storage Storage) ContextStorage {
+	if storage == nil {
+		return nil
 	}
+	return StorageWithContext(storage)
+}
+
+func (a *Authenticator) validateLifetimes() error {
+	if a.lifetime < 0 || a.refreshLifetime < 0 || a.maxSessionLifetime < 0 {
+		return ErrLifetimeInvalid
+	}
+
+	// A refresh token that expires before its token is never useful
+	if a.refreshLifetime > 0 && (a.lifetime == 0 || a.refreshLifetime < a.lifetime) {
+		return ErrLifetimeInvalid
+	}
+
+	if a.maxSessionLifetime > 0 && a.lifetime > a.maxSessionLifetime {
+		return ErrLifetimeInvalid
+	}
+
+	return nil
+}
+
+// NewVerifyingAuthenticator creates an Authenticator that can only validate
+// tokens, such as one backed by a RemoteVerifier
+func NewVerifyingAuthenticator(verifier Verifier, options ...AuthenticatorOption) *Authenticator {
+	a := &Authenticator{
+		verifier: verifier,
+	}
+
+	for _, option := range options {
+		option(a)
+	}
+
+	return a
 }
 
 // Authenticate user and generate token
 func (a *Authenticator) Authenticate(user string, pass string) (string, string, error) {
-	u, err := a.storage.Authenticate(user, pass)
+	return a.AuthenticateSession(user, pass, SessionInfo{})
+}
+
+// AuthenticateSession authenticates a user and generates a token, if sessions
+// are enabled the session is created with the info
+func (a *Authenticator) AuthenticateSession(user string, pass string, info SessionInfo) (string, string, error) {
+	return a.authenticate(context.Background(), nil, nil, user, pass, info)
+}
+
+// AuthenticateContext authenticates a user and generates a token, the context
+// and login request are passed to the storage. The request can be nil.
+func (a *Authenticator) AuthenticateContext(ctx context.Context, r *http.Request, user string, pass string) (string, string, error) {
+	var info SessionInfo
+	if r != nil {
+		info = SessionInfoFromRequest(r)
+	}
+
+	return a.authenticate(ctx, r, nil, user, pass, info)
+}
+
+// AuthenticateClient authenticates a user for an OAuth 2.0 client, such as in
+// the password grant. The tokens have a client_id claim and their permissions
+// are limited to the scopes of the client, roles are expanded when the tokens
+// are generated. The refresh token can only be used by the same client, see
+// RefreshClient.
+func (a *Authenticator) AuthenticateClient(ctx context.Context, r *http.Request, client *Client, user string, pass string) (string, string, error) {
+	var info SessionInfo
+	if r != nil {
+		info = SessionInfoFromRequest(r)
+	}
+
+	return a.authenticate(ctx, r, client, user, pass, info)
+}
+
+func (a *Authenticator) authenticate(ctx context.Context, r *http.Request, client *Client, user string, pass string, info SessionInfo) (string, string, error) {
+	if a.generator == nil || a.storage == nil {
+		return "", "", ErrCannotSign
+	}
+
+	u, err := a.storage.AuthenticateContext(ctx, r, user, pass)
 	if err != nil {
 		return "", "", err
 	}
 
-	token, err := a.Generate(u)
+	now := time.Now()
+	s := &session{authTime: now}
+
+	if client != nil {
+		u = a.clientUser(u, client)
+		s.clientID = client.ID
+	}
+
+	if a.sessions != nil {
+		if s.id, err = newID(); err != nil {
+			return "", "", err
+		}
+
+		err = a.sessions.Create(&Session{
+			ID:        s.id,
+			UID:       u.UID,
+			Created:   now,
+			LastSeen:  now,
+			UserAgent: info.UserAgent,
+			IP:        info.IP,
+		})
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	token, err := a.generate(ctx, u, s, now)
 	if err != nil {
 		return "", "", err
 	}
 
-	refresh, err := a.GenerateRefresh(u)
+	refresh, err := a.generateRefresh(ctx, u, s, now)
 	if err != nil {
 		return "", "", err
 	}
@@ -39,97 +317,588 @@ func (a *Authenticator) Authenticate(user string, pass string) (string, string,
 	return token, refresh, nil
 }
 
-// ValidateToken token and return UID
+// clientUser limits the permissions of a user to the scopes of a client, the
+// roles are expanded first as they are not limited when tokens are validated
+func (a *Authenticator) clientUser(user *User, client *Client) *User {
+	expanded := a.user(&Claims{UID: user.UID, Permissions: user.Permissions, Roles: user.Roles})
+
+	limited := *user
+	limited.Permissions = limitPermissions(expanded.Permissions, client.Scopes)
+	limited.Roles = nil
+
+	return &limited
+}
+
+// ValidateToken token and return UID, tokens issued to OAuth 2.0 clients have
+// no UID, see User.IsClient
 func (a *Authenticator) ValidateToken(token string) (*User, error) {
-	user, typ, err := a.validate(token)
+	_, claims, err := a.validate(token, "token")
 	if err != nil {
 		return nil, err
 	}
-	if typ != "token" {
-		return nil, ErrTokenInvalid
+	return a.user(claims), nil
+}
+
+// user returns the user of validated claims, with the permissions of their
+// roles
+func (a *Authenticator) user(claims *Claims) *User {
+	user := claims.User()
+	if a.roles != nil && len(user.Roles) > 0 {
+		user.Permissions = appendUnique(append([]string{}, user.Permissions...), a.roles.Permissions(user.Roles...)...)
 	}
-	return user, nil
+	return user
+}
+
+// ValidateTokenClaims validates a token and decodes its claims into v, which
+// can be an application defined claims struct
+func (a *Authenticator) ValidateTokenClaims(token string, v interface{}) (*User, error) {
+	parsed, claims, err := a.validate(token, "token")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := DecodeClaims(parsed, v); err != nil {
+		return nil, err
+	}
+
+	return a.user(claims), nil
 }
 
 // ValidateRefresh refresh token and return UID
 func (a *Authenticator) ValidateRefresh(token string) (*User, error) {
-	user, typ, err := a.validate(token)
+	_, claims, err := a.validate(token, "refresh")
 	if err != nil {
 		return nil, err
 	}
-	if typ != "refresh" {
-		return nil, ErrTokenInvalid
+
+	// Refresh tokens generated before rotation was enabled have no family
+	if a.families != nil && claims.Family != "" {
+		if err := a.families.Check(claims.Family, claims.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	return a.user(claims), nil
+}
+
+// Refresh validates a refresh token and generates a new token and refresh
+// token. If rotation is enabled the refresh token can not be used again.
+// Refresh tokens issued to OAuth 2.0 clients are refused, see RefreshClient.
+func (a *Authenticator) Refresh(refresh string) (string, string, error) {
+	return a.RefreshClient(context.Background(), refresh, nil)
+}
+
+// RefreshClient is Refresh for an OAuth 2.0 client, the refresh token must
+// have been issued to the client. The client is nil for refresh tokens issued
+// without a client. The context is passed to the Signer.
+func (a *Authenticator) RefreshClient(ctx context.Context, refresh string, client *Client) (string, string, error) {
+	if a.generator == nil {
+		return "", "", ErrCannotSign
+	}
+
+	_, claims, err := a.validate(refresh, "refresh")
+	if err != nil {
+		return "", "", err
+	}
+
+	// RFC 6749 section 6, refresh tokens are bound to their client
+	clientID := ""
+	if client != nil {
+		clientID = client.ID
+	}
+	if claims.ClientID != clientID {
+		return "", "", ErrRefreshClientInvalid
+	}
+
+	user := claims.User()
+	now := time.Now()
+	s := &session{
+		authTime:       claims.authTime(),
+		refreshExpires: claims.ExpiresAt,
+		family:         claims.Family,
+		previous:       claims.ID,
+		id:             claims.SessionID,
+		clientID:       claims.ClientID,
+	}
+
+	if a.sessions != nil && s.id != "" {
+		if err := a.sessions.Touch(s.id, now); err != nil {
+			return "", "", a.sessionError(err)
+		}
+	}
+
+	// The family is checked first so a reused refresh token is never
+	// exchanged for a token
+	if a.families != nil && s.family != "" {
+		if err := a.families.Check(s.family, s.previous); err != nil {
+			return "", "", err
+		}
+	}
+
+	next, claims, err := a.signRefresh(ctx, user, s, now)
+	if err != nil {
+		return "", "", err
+	}
+
+	token, err := a.generate(ctx, user, s, now)
+	if err != nil {
+		return "", "", err
+	}
+
+	// The family is only rotated once both tokens are signed, if signing
+	// fails the refresh token can still be used
+	if err := a.rotateFamily(s, claims); err != nil {
+		return "", "", err
+	}
+
+	return token, next, nil
+}
+
+func (a *Authenticator) validate(token string, typ string) (*jwt.Token, *Claims, error) {
+	parsed, err := a.verifier.Verify(token)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	claims, err := ParseClaims(parsed)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := a.validateIssuer(claims); err != nil {
+		return nil, nil, err
+	}
+
+	if err := a.validateAudience(claims); err != nil {
+		return nil, nil, err
+	}
+
+	// Client tokens have no uid, every other token must have one
+	if claims.UID == "" && claims.ClientID == "" {
+		return nil, nil, &ClaimError{Claim: "uid", Err: ErrClaimMissing}
+	}
+
+	// Tokens generated before sub was added only have a uid
+	if claims.Subject != "" && claims.Subject != claims.UID {
+		return nil, nil, &ClaimError{Claim: "sub", Err: ErrClaimInvalid}
+	}
+
+	if claims.Type != typ {
+		return nil, nil, ErrTokenTypeInvalid
+	}
+
+	if err := a.validateRevocation(claims); err != nil {
+		return nil, nil, err
+	}
+
+	if err := a.validateSession(claims); err != nil {
+		return nil, nil, err
+	}
+
+	return parsed, claims, nil
+}
+
+// validateSession checks the session of the token is alive, tokens generated
+// before sessions were enabled have no sid
+func (a *Authenticator) validateSession(claims *Claims) error {
+	if a.sessions == nil || claims.SessionID == "" {
+		return nil
+	}
+
+	session, err := a.sessions.Get(claims.SessionID)
+	if err != nil {
+		return a.sessionError(err)
+	}
+
+	if session.UID != claims.UID {
+		return &ClaimError{Claim: "sid", Err: ErrClaimInvalid}
+	}
+
+	return nil
+}
+
+// sessionError converts a missing session into ErrTokenRevoked
+func (a *Authenticator) sessionError(err error) error {
+	if err == ErrSessionNotFound {
+		return ErrTokenRevoked
+	}
+	return err
+}
+
+// Sessions returns the alive sessions of a user
+func (a *Authenticator) Sessions(uid string) ([]*Session, error) {
+	if a.sessions == nil {
+		return nil, ErrSessionsUnsupported
+	}
+
+	return a.sessions.List(uid)
+}
+
+// RevokeSession ends a session, every token generated for it is no longer
+// valid
+func (a *Authenticator) RevokeSession(id string) error {
+	if a.sessions == nil {
+		return ErrSessionsUnsupported
+	}
+
+	return a.sessions.Revoke(id)
+}
+
+// RevokeSessions ends every session of a user, such as when they log out of
+// all devices
+func (a *Authenticator) RevokeSessions(uid string) error {
+	if a.sessions == nil {
+		return ErrSessionsUnsupported
+	}
+
+	return a.sessions.RevokeUser(uid)
+}
+
+func (a *Authenticator) validateRevocation(claims *Claims) error {
+	if a.revocations == nil {
+		return nil
+	}
+
+	revoked, err := a.revocations.Revoked(claims.ID, claims.UID, time.Unix(claims.IssuedAt, 0))
+	if err != nil {
+		return err
+	}
+
+	if revoked {
+		return ErrTokenRevoked
+	}
+
+	return nil
+}
+
+// Revoke revokes a token or refresh token so it is no longer valid, revoking
+// a refresh token also revokes its family
+func (a *Authenticator) Revoke(token string) error {
+	claims, err := a.revocationClaims(token)
+	if err != nil {
+		return err
 	}
-	return user, nil
+
+	return a.revoke(claims)
 }
 
-func (a *Authenticator) validate(token string) (*User, string, error) {
-	parsed, err := a.generator.Verify(token)
+// RevokeClient is Revoke for an OAuth 2.0 client, the token must have been
+// issued to the client, RFC 7009 section 2.1. The client is nil for tokens
+// issued without a client.
+func (a *Authenticator) RevokeClient(token string, client *Client) error {
+	claims, err := a.revocationClaims(token)
 	if err != nil {
-		return nil, "", err
+		return err
 	}
 
-	uid, ok := parsed.Claims["uid"].(string)
-	if !ok {
-		return nil, "", ErrTokenInvalid
+	clientID := ""
+	if client != nil {
+		clientID = client.ID
 	}
+	if claims.ClientID != clientID {
+		return ErrTokenClientInvalid
+	}
+
+	return a.revoke(claims)
+}
 
-	typ, ok := parsed.Claims["type"].(string)
-	if !ok {
-		return nil, "", ErrTokenInvalid
+// revocationClaims verifies a token that is going to be revoked
+func (a *Authenticator) revocationClaims(token string) (*Claims, error) {
+	if a.revocations == nil && a.families == nil {
+		return nil, ErrRevocationUnsupported
 	}
 
-	permissions, ok := parsed.Claims["permissions"].([]interface{})
-	if !ok {
-		return nil, "", ErrTokenInvalid
+	parsed, err := a.verifier.Verify(token)
+	if err != nil {
+		return nil, err
 	}
 
-	user := &User{
-		UID:         uid,
-		Permissions: make([]string, 0, len(permissions)),
+	return ParseClaims(parsed)
+}
+
+func (a *Authenticator) revoke(claims *Claims) error {
+	family := a.families != nil && claims.Family != ""
+	if family {
+		if err := a.families.Revoke(claims.Family); err != nil {
+			return err
+		}
 	}
 
-	for _, permission := range permissions {
-		if p, ok := permission.(string); ok {
-			user.Permissions = append(user.Permissions, p)
+	if a.revocations == nil {
+		if family {
+			return nil
 		}
+		return ErrRevocationUnsupported
+	}
+
+	if claims.ID == "" {
+		return &ClaimError{Claim: "jti", Err: ErrClaimMissing}
 	}
 
-	return user, typ, nil
+	return a.revocations.Revoke(claims.ID, claims.expiry())
+}
+
+// RevokeUser revokes every token issued to a user so far, such as when they
+// log out of all devices. The iat claim only has second precision, so tokens
+// issued in the current second are not revoked, otherwise logging in again
+// straight after would give a revoked token.
+func (a *Authenticator) RevokeUser(uid string) error {
+	if a.revocations == nil {
+		return ErrRevocationUnsupported
+	}
+
+	return a.revocations.RevokeUser(uid, time.Unix(time.Now().Unix(), 0))
+}
+
+func (a *Authenticator) validateIssuer(claims *Claims) error {
+	if a.issuer == "" {
+		return nil
+	}
+
+	if claims.Issuer == "" {
+		return &ClaimError{Claim: "iss", Err: ErrClaimMissing}
+	}
+
+	if claims.Issuer != a.issuer {
+		return ErrIssuerInvalid
+	}
+
+	return nil
+}
+
+func (a *Authenticator) validateAudience(claims *Claims) error {
+	if len(a.audience) == 0 {
+		return nil
+	}
+
+	if len(claims.Audience) == 0 {
+		return &ClaimError{Claim: "aud", Err: ErrClaimMissing}
+	}
+
+	if !claims.Audience.Contains(a.audience...) {
+		return ErrAudienceInvalid
+	}
+
+	return nil
+}
+
+// session is carried from a refresh token to the tokens generated from it
+type session struct {
+	// authTime is when the user authenticated
+	authTime time.Time
+
+	// refreshExpires is the exp of the refresh token being replaced
+	refreshExpires int64
+
+	// family and previous are the family and jti of the refresh token being
+	// replaced
+	family   string
+	previous string
+
+	// id is the ID of the Session, it is empty if sessions are not enabled
+	id string
+
+	// clientID is the OAuth 2.0 client the tokens are issued to
+	clientID string
+}
+
+// expiry returns the exp claim for a token with the lifetime, it is never
+// after the end of the session
+func (a *Authenticator) expiry(s *session, now time.Time, lifetime time.Duration) int64 {
+	var exp time.Time
+	if lifetime > 0 {
+		exp = now.Add(lifetime)
+	}
+
+	if a.maxSessionLifetime > 0 {
+		end := s.authTime.Add(a.maxSessionLifetime)
+		if exp.IsZero() || end.Before(exp) {
+			exp = end
+		}
+	}
+
+	if exp.IsZero() {
+		return 0
+	}
+	return exp.Unix()
+}
+
+// claims creates the registered and user claims for a token
+func (a *Authenticator) claims(user *User, typ string, s *session, now time.Time) (*Claims, error) {
+	for name := range user.Claims {
+		if IsReservedClaim(name) {
+			return nil, &ClaimError{Claim: name, Err: ErrClaimReserved}
+		}
+	}
+
+	jti, err := newID()
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &Claims{
+		ID:          jti,
+		Subject:     user.UID,
+		Issuer:      a.issuer,
+		Audience:    Audience(a.audience),
+		IssuedAt:    now.Unix(),
+		NotBefore:   now.Unix(),
+		AuthTime:    s.authTime.Unix(),
+		SessionID:   s.id,
+		ClientID:    s.clientID,
+		UID:         user.UID,
+		Type:        typ,
+		Permissions: user.Permissions,
+		Roles:       user.Roles,
+		Extra:       user.Claims,
+	}
+
+	if claims.Permissions == nil {
+		claims.Permissions = []string{}
+	}
+
+	return claims, nil
+}
+
+// sign signs the claims, the context is passed to the Signer
+func (a *Authenticator) sign(ctx context.Context, claims *Claims) (string, error) {
+	m, err := claims.Map()
+	if err != nil {
+		return "", err
+	}
+
+	token := a.generator.Create()
+	token.Claims = m
+
+	return a.generator.SignContext(ctx, token)
 }
 
 // Generate token for UID
 func (a *Authenticator) Generate(user *User) (string, error) {
-	token := a.generator.Create()
+	now := time.Now()
+	return a.generate(context.Background(), user, &session{authTime: now}, now)
+}
 
-	if a.lifetime > 0 {
-		token.Claims["exp"] = time.Now().Add(a.lifetime).Unix()
+func (a *Authenticator) generate(ctx context.Context, user *User, s *session, now time.Time) (string, error) {
+	if a.generator == nil {
+		return "", ErrCannotSign
 	}
 
-	token.Claims["uid"] = user.UID
-	token.Claims["type"] = "token"
-	token.Claims["permissions"] = []string{}
-	if user.Permissions != nil {
-		token.Claims["permissions"] = user.Permissions
+	claims, err := a.claims(user, "token", s, now)
+	if err != nil {
+		return "", err
 	}
 
-	return a.generator.Sign(token)
+	claims.ExpiresAt = a.expiry(s, now, a.lifetime)
+
+	return a.sign(ctx, claims)
 }
 
-// GenerateRefresh generates a refresh token for UID
+// GenerateRefresh generates a refresh token for UID, if rotation is enabled
+// a new family is created
 func (a *Authenticator) GenerateRefresh(user *User) (string, error) {
-	token := a.generator.Create()
+	now := time.Now()
+	return a.generateRefresh(context.Background(), user, &session{authTime: now}, now)
+}
+
+// generateRefresh generates a refresh token, if the session has a family the
+// previous refresh token in the family is replaced
+func (a *Authenticator) generateRefresh(ctx context.Context, user *User, s *session, now time.Time) (string, error) {
+	refresh, claims, err := a.signRefresh(ctx, user, s, now)
+	if err != nil {
+		return "", err
+	}
+
+	if err := a.rotateFamily(s, claims); err != nil {
+		return "", err
+	}
+
+	return refresh, nil
+}
+
+// signRefresh signs a refresh token without changing the family store, the
+// family claim is the family of the session or a new one
+func (a *Authenticator) signRefresh(ctx context.Context, user *User, s *session, now time.Time) (string, *Claims, error) {
+	if a.generator == nil {
+		return "", nil, ErrCannotSign
+	}
+
+	claims, err := a.claims(user, "refresh", s, now)
+	if err != nil {
+		return "", nil, err
+	}
+
+	claims.ExpiresAt = a.expiry(s, now, a.refreshLifetime)
+
+	// Without sliding a refresh never extends the refresh token expiry
+	if !a.slidingRefresh && s.refreshExpires > 0 && s.refreshExpires < claims.ExpiresAt {
+		claims.ExpiresAt = s.refreshExpires
+	}
+
+	if a.families != nil {
+		claims.Family = s.family
+		if claims.Family == "" {
+			if claims.Family, err = newID(); err != nil {
+				return "", nil, err
+			}
+		}
+	}
+
+	refresh, err := a.sign(ctx, claims)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return refresh, claims, nil
+}
 
-	if a.lifetime > 0 {
-		token.Claims["exp"] = time.Now().Add(a.refreshLifetime).Unix()
+// rotateFamily records a signed refresh token in its family, creating the
+// family or replacing the previous refresh token of the session
+func (a *Authenticator) rotateFamily(s *session, claims *Claims) error {
+	if a.families == nil {
+		return nil
 	}
 
-	token.Claims["uid"] = user.UID
-	token.Claims["type"] = "refresh"
-	token.Claims["permissions"] = []string{}
-	if user.Permissions != nil {
-		token.Claims["permissions"] = user.Permissions
+	if s.family == "" {
+		return a.families.Create(claims.Family, claims.ID, claims.expiry())
 	}
+	return a.families.Rotate(claims.Family, s.previous, claims.ID, claims.expiry())
+}
+
+// GenerateClient generates a token for an OAuth 2.0 client with the scopes as
+// permissions, the lifetime of the client is used if it is set. The token has
+// a client_id claim and no uid, see User.IsClient. Clients do not get refresh
+// tokens. The context is passed to the Signer.
+func (a *Authenticator) GenerateClient(ctx context.Context, client *Client, scopes []string) (string, error) {
+	if a.generator == nil {
+		return "", ErrCannotSign
+	}
+
+	now := time.Now()
+	s := &session{authTime: now, clientID: client.ID}
 
-	return a.generator.Sign(token)
+	// Clients are kept out of the user namespace, their tokens have no uid
+	// so a client can never act as a user with the same ID
+	claims, err := a.claims(&User{Permissions: scopes}, "token", s, now)
+	if err != nil {
+		return "", err
+	}
+
+	lifetime := a.lifetime
+	if client.Lifetime > 0 {
+		lifetime = client.Lifetime
+	}
+
+	claims.ExpiresAt = a.expiry(s, now, lifetime)
+
+	return a.sign(ctx, claims)
+}
+
+// newID generates a random id for the jti claim and refresh token families
+func newID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return jwt.EncodeSegment(id), nil
 }
@@ -1,6 +1,8 @@
 package auth
 
 import (
+	"context"
+	"errors"
 	"testing"
 	"time"
 
@@ -71,7 +73,7 @@ func TestAuthenticator(t *testing.T) {
 
 			Convey("Then an error should be returned", func() {
 				So(user, ShouldBeNil)
-				So(err, ShouldNotBeNil)
+				So(err, ShouldEqual, ErrTokenTypeInvalid)
 			})
 		})
 
@@ -83,7 +85,7 @@ func TestAuthenticator(t *testing.T) {
 
 			Convey("Then an error should be returned", func() {
 				So(user, ShouldBeNil)
-				So(err, ShouldNotBeNil)
+				So(err, ShouldEqual, ErrTokenTypeInvalid)
 			})
 		})
 
@@ -92,7 +94,7 @@ func TestAuthenticator(t *testing.T) {
 
 			Convey("Then an error should be returned", func() {
 				So(user, ShouldBeNil)
-				So(err, ShouldNotBeNil)
+				So(err, ShouldEqual, ErrTokenMalformed)
 			})
 		})
 
@@ -101,7 +103,7 @@ func TestAuthenticator(t *testing.T) {
 
 			Convey("Then an error should be returned", func() {
 				So(user, ShouldBeNil)
-				So(err, ShouldNotBeNil)
+				So(err, ShouldEqual, ErrTokenMalformed)
 			})
 		})
 
@@ -113,9 +115,9 @@ func TestAuthenticator(t *testing.T) {
 
 			user, err := auth.ValidateToken(token)
 
-			Convey("hen an error should be returned", func() {
+			Convey("Then an error should be returned", func() {
 				So(user, ShouldBeNil)
-				So(err, ShouldNotBeNil)
+				So(errors.Is(err, ErrClaimMissing), ShouldBeTrue)
 			})
 		})
 
@@ -130,7 +132,7 @@ func TestAuthenticator(t *testing.T) {
 
 			Convey("Then an error should be returned", func() {
 				So(user, ShouldBeNil)
-				So(err, ShouldNotBeNil)
+				So(errors.Is(err, ErrClaimMissing), ShouldBeTrue)
 			})
 		})
 
@@ -145,7 +147,437 @@ func TestAuthenticator(t *testing.T) {
 
 			Convey("Then an error should be returned", func() {
 				So(user, ShouldBeNil)
-				So(err, ShouldNotBeNil)
+				So(errors.Is(err, ErrClaimMissing), ShouldBeTrue)
+			})
+		})
+	})
+}
+
+func TestClaimError(t *testing.T) {
+	if !testing.Verbose() {
+		t.Parallel()
+	}
+
+	Convey("Given an Authenticator", t, func() {
+		auth := NewMockAuthenticator("test_uid", "test_user", "test_pass", nil)
+
+		Convey("When a token with a non string UID is validated", func() {
+			tok := auth.generator.Create()
+			tok.Claims["uid"] = 1
+			tok.Claims["type"] = "token"
+			tok.Claims["permissions"] = []string{}
+			token, err := auth.generator.Sign(tok)
+			So(err, ShouldBeNil)
+
+			user, err := auth.ValidateToken(token)
+
+			Convey("Then a ClaimError naming the claim should be returned", func() {
+				So(user, ShouldBeNil)
+
+				claimErr, ok := err.(*ClaimError)
+				So(ok, ShouldBeTrue)
+				So(claimErr.Claim, ShouldEqual, "uid")
+				So(claimErr.Err, ShouldEqual, ErrClaimInvalid)
+				So(ErrorCode(err), ShouldEqual, CodeClaimInvalid)
+			})
+		})
+	})
+}
+
+func TestAuthenticatorRegisteredClaims(t *testing.T) {
+	if !testing.Verbose() {
+		t.Parallel()
+	}
+
+	Convey("Given an Authenticator with an issuer and audience", t, func() {
+		generator := NewMockTokenGenerator()
+		storage := NewMockStorage("test_uid", "test_user", "test_pass", nil)
+		auth := NewAuthenticator(generator, storage, time.Hour, time.Hour,
+			WithIssuer("issuer"),
+			WithAudience("service1"),
+		)
+
+		Convey("When a token is generated", func() {
+			token, err := auth.Generate(&User{UID: "test_uid"})
+			So(err, ShouldBeNil)
+
+			parsed, err := generator.Verify(token)
+			So(err, ShouldBeNil)
+
+			Convey("Then the registered claims should be set", func() {
+				So(parsed.Claims["iss"], ShouldEqual, "issuer")
+				So(parsed.Claims["aud"], ShouldEqual, "service1")
+				So(parsed.Claims["sub"], ShouldEqual, "test_uid")
+				So(parsed.Claims["iat"], ShouldNotBeNil)
+				So(parsed.Claims["nbf"], ShouldNotBeNil)
+				So(parsed.Claims["jti"], ShouldNotBeEmpty)
+			})
+
+			Convey("Then the jti should be unique", func() {
+				other, err := auth.Generate(&User{UID: "test_uid"})
+				So(err, ShouldBeNil)
+
+				otherParsed, err := generator.Verify(other)
+				So(err, ShouldBeNil)
+				So(otherParsed.Claims["jti"], ShouldNotEqual, parsed.Claims["jti"])
+			})
+
+			Convey("Then the token should be valid", func() {
+				user, err := auth.ValidateToken(token)
+				So(err, ShouldBeNil)
+				So(user.UID, ShouldEqual, "test_uid")
+			})
+		})
+
+		Convey("When a token from another issuer is validated", func() {
+			other := NewAuthenticator(generator, storage, time.Hour, time.Hour,
+				WithIssuer("other"),
+				WithAudience("service1"),
+			)
+
+			token, err := other.Generate(&User{UID: "test_uid"})
+			So(err, ShouldBeNil)
+
+			user, err := auth.ValidateToken(token)
+
+			Convey("Then ErrIssuerInvalid should be returned", func() {
+				So(user, ShouldBeNil)
+				So(err, ShouldEqual, ErrIssuerInvalid)
+			})
+		})
+
+		Convey("When a token for another audience is validated", func() {
+			other := NewAuthenticator(generator, storage, time.Hour, time.Hour,
+				WithIssuer("issuer"),
+				WithAudience("service2", "service3"),
+			)
+
+			token, err := other.Generate(&User{UID: "test_uid"})
+			So(err, ShouldBeNil)
+
+			user, err := auth.ValidateToken(token)
+
+			Convey("Then ErrAudienceInvalid should be returned", func() {
+				So(user, ShouldBeNil)
+				So(err, ShouldEqual, ErrAudienceInvalid)
+			})
+		})
+
+		Convey("When a token for multiple audiences is validated", func() {
+			other := NewAuthenticator(generator, storage, time.Hour, time.Hour,
+				WithIssuer("issuer"),
+				WithAudience("service2", "service1"),
+			)
+
+			token, err := other.Generate(&User{UID: "test_uid"})
+			So(err, ShouldBeNil)
+
+			user, err := auth.ValidateToken(token)
+
+			Convey("Then the token should be valid", func() {
+				So(err, ShouldBeNil)
+				So(user.UID, ShouldEqual, "test_uid")
+			})
+		})
+
+		Convey("When a token without an issuer is validated", func() {
+			other := NewAuthenticator(generator, storage, time.Hour, time.Hour)
+
+			token, err := other.Generate(&User{UID: "test_uid"})
+			So(err, ShouldBeNil)
+
+			user, err := auth.ValidateToken(token)
+
+			Convey("Then a missing claim error should be returned", func() {
+				So(user, ShouldBeNil)
+				So(errors.Is(err, ErrClaimMissing), ShouldBeTrue)
+			})
+		})
+
+		Convey("When a token with a sub that does not match the uid is validated", func() {
+			tok := generator.Create()
+			tok.Claims["iss"] = "issuer"
+			tok.Claims["aud"] = "service1"
+			tok.Claims["sub"] = "other_uid"
+			tok.Claims["uid"] = "test_uid"
+			tok.Claims["type"] = "token"
+			tok.Claims["permissions"] = []string{}
+			token, err := generator.Sign(tok)
+			So(err, ShouldBeNil)
+
+			user, err := auth.ValidateToken(token)
+
+			Convey("Then an invalid claim error should be returned", func() {
+				So(user, ShouldBeNil)
+				So(errors.Is(err, ErrClaimInvalid), ShouldBeTrue)
+			})
+		})
+	})
+}
+
+func TestAuthenticatorCustomClaims(t *testing.T) {
+	if !testing.Verbose() {
+		t.Parallel()
+	}
+
+	Convey("Given an Authenticator with storage that returns custom claims", t, func() {
+		storage := &mockStorage{
+			UID:      "test_uid",
+			Username: "test_user",
+			Password: "test_pass",
+			Claims: map[string]interface{}{
+				"tenant": "tenant1",
+				"email":  "test@example.com",
+				"attributes": map[string]interface{}{
+					"theme": "dark",
+				},
+			},
+		}
+		auth := NewAuthenticator(NewMockTokenGenerator(), storage, time.Hour, time.Hour)
+
+		Convey("When a user is authenticated and the token validated", func() {
+			token, refresh, err := auth.Authenticate("test_user", "test_pass")
+			So(err, ShouldBeNil)
+
+			user, err := auth.ValidateToken(token)
+			So(err, ShouldBeNil)
+
+			Convey("Then the custom claims should be returned", func() {
+				So(user.Claims["tenant"], ShouldEqual, "tenant1")
+				So(user.Claims["email"], ShouldEqual, "test@example.com")
+				So(user.Claims["attributes"], ShouldResemble, map[string]interface{}{"theme": "dark"})
+			})
+
+			Convey("Then the reserved claims should not be returned", func() {
+				So(user.Claims, ShouldNotContainKey, "uid")
+				So(user.Claims, ShouldNotContainKey, "exp")
+				So(user.Claims, ShouldNotContainKey, "type")
+			})
+
+			Convey("Then the refresh token should carry the custom claims", func() {
+				user, err := auth.ValidateRefresh(refresh)
+				So(err, ShouldBeNil)
+				So(user.Claims["tenant"], ShouldEqual, "tenant1")
+			})
+		})
+
+		Convey("When a user with a reserved claim is authenticated", func() {
+			storage.Claims = map[string]interface{}{
+				"type": "refresh",
+			}
+
+			token, _, err := auth.Authenticate("test_user", "test_pass")
+
+			Convey("Then ErrClaimReserved should be returned", func() {
+				So(token, ShouldBeEmpty)
+				So(errors.Is(err, ErrClaimReserved), ShouldBeTrue)
+			})
+		})
+
+		Convey("When a user without custom claims is validated", func() {
+			storage.Claims = nil
+
+			token, _, err := auth.Authenticate("test_user", "test_pass")
+			So(err, ShouldBeNil)
+
+			user, err := auth.ValidateToken(token)
+
+			Convey("Then the claims should be nil", func() {
+				So(err, ShouldBeNil)
+				So(user.Claims, ShouldBeNil)
+			})
+		})
+	})
+}
+
+func TestAuthenticatorLifetimes(t *testing.T) {
+	if !testing.Verbose() {
+		t.Parallel()
+	}
+
+	Convey("Given a generator and storage", t, func() {
+		generator := NewMockTokenGenerator()
+		storage := NewMockStorage("test_uid", "test_user", "test_pass", []string{"permission1"})
+
+		claims := func(str string) *Claims {
+			token, err := generator.Verify(str)
+			So(err, ShouldBeNil)
+			claims, err := ParseClaims(token)
+			So(err, ShouldBeNil)
+			return claims
+		}
+
+		Convey("When an Authenticator is created with invalid lifetimes", func() {
+			invalid := [][]AuthenticatorOption{
+				{WithLifetime(-time.Hour)},
+				{WithLifetime(time.Hour), WithRefreshLifetime(-time.Hour)},
+				{WithLifetime(time.Hour), WithMaxSessionLifetime(-time.Hour)},
+				{WithLifetime(time.Hour), WithRefreshLifetime(time.Minute)},
+				{WithRefreshLifetime(time.Hour)},
+				{WithLifetime(time.Hour), WithMaxSessionLifetime(time.Minute)},
+			}
+
+			Convey("Then ErrLifetimeInvalid should be returned", func() {
+				for _, options := range invalid {
+					auth, err := New(generator, storage, options...)
+					So(auth, ShouldBeNil)
+					So(err, ShouldEqual, ErrLifetimeInvalid)
+				}
+			})
+		})
+
+		Convey("When an Authenticator is created with valid lifetimes", func() {
+			valid := [][]AuthenticatorOption{
+				{},
+				{WithLifetime(time.Hour)},
+				{WithLifetime(time.Hour), WithRefreshLifetime(time.Hour)},
+				{WithLifetime(time.Hour), WithRefreshLifetime(time.Hour * 24), WithMaxSessionLifetime(time.Hour)},
+				{WithMaxSessionLifetime(time.Hour)},
+			}
+
+			Convey("Then no error should be returned", func() {
+				for _, options := range valid {
+					auth, err := New(generator, storage, options...)
+					So(err, ShouldBeNil)
+					So(auth, ShouldNotBeNil)
+				}
+			})
+		})
+
+		Convey("When tokens are generated with access and refresh lifetimes", func() {
+			auth, err := New(generator, storage,
+				WithLifetime(time.Hour),
+				WithRefreshLifetime(time.Hour*24),
+			)
+			So(err, ShouldBeNil)
+
+			now := time.Now().Unix()
+			token, refresh, err := auth.Authenticate("test_user", "test_pass")
+			So(err, ShouldBeNil)
+
+			Convey("Then each token should use its own lifetime", func() {
+				So(claims(token).ExpiresAt, ShouldAlmostEqual, now+3600, 1)
+				So(claims(refresh).ExpiresAt, ShouldAlmostEqual, now+86400, 1)
+			})
+
+			Convey("Then both tokens should have the same auth_time", func() {
+				So(claims(token).AuthTime, ShouldEqual, claims(refresh).AuthTime)
+				So(claims(token).AuthTime, ShouldAlmostEqual, now, 1)
+			})
+		})
+
+		Convey("When tokens are generated with zero lifetimes", func() {
+			auth, err := New(generator, storage)
+			So(err, ShouldBeNil)
+
+			token, refresh, err := auth.Authenticate("test_user", "test_pass")
+			So(err, ShouldBeNil)
+
+			Convey("Then the tokens should not expire", func() {
+				So(claims(token).ExpiresAt, ShouldEqual, 0)
+				So(claims(refresh).ExpiresAt, ShouldEqual, 0)
+			})
+		})
+
+		Convey("When tokens are generated with a legacy NewAuthenticator", func() {
+			auth := NewAuthenticator(generator, storage, time.Hour, time.Hour*24)
+
+			now := time.Now().Unix()
+			refresh, err := auth.GenerateRefresh(&User{UID: "test_uid"})
+			So(err, ShouldBeNil)
+
+			Convey("Then the refresh token should use the refresh lifetime", func() {
+				So(claims(refresh).ExpiresAt, ShouldAlmostEqual, now+86400, 1)
+			})
+		})
+
+		Convey("When a session started long ago is refreshed", func() {
+			user := &User{UID: "test_uid", Permissions: []string{"permission1"}}
+			authTime := time.Now().Add(-time.Hour * 23)
+
+			Convey("And the session has a max lifetime", func() {
+				auth, err := New(generator, storage,
+					WithLifetime(time.Hour*2),
+					WithRefreshLifetime(time.Hour*24),
+					WithMaxSessionLifetime(time.Hour*24),
+				)
+				So(err, ShouldBeNil)
+
+				refresh, err := auth.generateRefresh(context.Background(), user, &session{authTime: authTime}, time.Now())
+				So(err, ShouldBeNil)
+
+				token, next, err := auth.Refresh(refresh)
+				So(err, ShouldBeNil)
+
+				Convey("Then no token should expire after the session", func() {
+					end := authTime.Add(time.Hour * 24).Unix()
+					So(claims(token).ExpiresAt, ShouldEqual, end)
+					So(claims(next).ExpiresAt, ShouldEqual, end)
+					So(claims(next).AuthTime, ShouldEqual, authTime.Unix())
+				})
+			})
+
+			Convey("And the session has no max lifetime", func() {
+				auth, err := New(generator, storage,
+					WithLifetime(time.Hour*2),
+					WithRefreshLifetime(time.Hour*24),
+				)
+				So(err, ShouldBeNil)
+
+				refresh, err := auth.generateRefresh(context.Background(), user, &session{authTime: authTime}, time.Now())
+				So(err, ShouldBeNil)
+
+				now := time.Now().Unix()
+				token, next, err := auth.Refresh(refresh)
+				So(err, ShouldBeNil)
+
+				Convey("Then the tokens should use their full lifetimes", func() {
+					So(claims(token).ExpiresAt, ShouldAlmostEqual, now+7200, 1)
+					So(claims(next).ExpiresAt, ShouldAlmostEqual, now+86400, 1)
+				})
+			})
+		})
+
+		Convey("When a refresh token is refreshed", func() {
+			user := &User{UID: "test_uid", Permissions: []string{"permission1"}}
+			issued := time.Now().Add(-time.Hour * 12)
+
+			Convey("And refreshes slide", func() {
+				auth, err := New(generator, storage,
+					WithLifetime(time.Hour),
+					WithRefreshLifetime(time.Hour*24),
+				)
+				So(err, ShouldBeNil)
+
+				refresh, err := auth.generateRefresh(context.Background(), user, &session{authTime: issued}, issued)
+				So(err, ShouldBeNil)
+
+				now := time.Now().Unix()
+				_, next, err := auth.Refresh(refresh)
+				So(err, ShouldBeNil)
+
+				Convey("Then the new refresh token should have a full refresh lifetime", func() {
+					So(claims(next).ExpiresAt, ShouldAlmostEqual, now+86400, 1)
+				})
+			})
+
+			Convey("And refreshes do not slide", func() {
+				auth, err := New(generator, storage,
+					WithLifetime(time.Hour),
+					WithRefreshLifetime(time.Hour*24),
+					WithSlidingRefresh(false),
+				)
+				So(err, ShouldBeNil)
+
+				refresh, err := auth.generateRefresh(context.Background(), user, &session{authTime: issued}, issued)
+				So(err, ShouldBeNil)
+
+				_, next, err := auth.Refresh(refresh)
+				So(err, ShouldBeNil)
+
+				Convey("Then the new refresh token should expire with the old one", func() {
+					So(claims(next).ExpiresAt, ShouldEqual, claims(refresh).ExpiresAt)
+				})
 			})
 		})
 	})
@@ -0,0 +1,238 @@
+package auth
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/pquerna/ffjson/ffjson"
+
+	"gopkg.in/dgrijalva/jwt-go.v2"
+)
+
+// Claims are the claims of tokens generated by Authenticator
+type Claims struct {
+	ID        string   `json:"jti,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Issuer    string   `json:"iss,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	AuthTime  int64    `json:"auth_time,omitempty"`
+
+	UID         string   `json:"uid"`
+	Type        string   `json:"type"`
+	Permissions []string `json:"permissions"`
+	Roles       []string `json:"roles,omitempty"`
+
+	// Family is the refresh token family, see FamilyStore
+	Family string `json:"fam,omitempty"`
+
+	// SessionID is the session the token was generated for, see SessionStore
+	SessionID string `json:"sid,omitempty"`
+
+	// ClientID is the OAuth 2.0 client the token was issued to, see
+	// Authenticator.GenerateClient
+	ClientID string `json:"client_id,omitempty"`
+
+	// Extra holds the claims that are not reserved, see User.Claims
+	Extra map[string]interface{} `json:"-"`
+}
+
+// requiredClaims must be present in tokens generated by Authenticator
+var requiredClaims = []string{"uid", "type", "permissions"}
+
+// claimsJSON has the fields of Claims without its methods
+type claimsJSON Claims
+
+// MarshalJSON implements json.Marshaler, Extra is merged into the object
+func (c *Claims) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal((*claimsJSON)(c))
+	if err != nil || len(c.Extra) == 0 {
+		return data, err
+	}
+
+	all := make(map[string]interface{})
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+
+	for name, value := range c.Extra {
+		if IsReservedClaim(name) {
+			return nil, &ClaimError{Claim: name, Err: ErrClaimReserved}
+		}
+		all[name] = value
+	}
+
+	return json.Marshal(all)
+}
+
+// UnmarshalJSON implements json.Unmarshaler, unreserved claims are stored in
+// Extra
+func (c *Claims) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, (*claimsJSON)(c)); err != nil {
+		return claimsError(err)
+	}
+
+	all := make(map[string]interface{})
+	if err := json.Unmarshal(data, &all); err != nil {
+		return claimsError(err)
+	}
+
+	c.Extra = nil
+	for name, value := range all {
+		if IsReservedClaim(name) {
+			continue
+		}
+		if c.Extra == nil {
+			c.Extra = make(map[string]interface{})
+		}
+		c.Extra[name] = value
+	}
+
+	return nil
+}
+
+// Map returns the claims as a map for use as jwt.Token.Claims
+func (c *Claims) Map() (map[string]interface{}, error) {
+	data, err := ffjson.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := make(map[string]interface{})
+	if err := ffjson.Unmarshal(data, &claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// User returns the user the claims were generated for
+func (c *Claims) User() *User {
+	user := &User{
+		UID:         c.UID,
+		Permissions: c.Permissions,
+		Roles:       c.Roles,
+		ClientID:    c.ClientID,
+		Claims:      c.Extra,
+	}
+
+	if user.Permissions == nil {
+		user.Permissions = []string{}
+	}
+
+	return user
+}
+
+// expiry returns the time the token expires, or zero if it does not expire
+func (c *Claims) expiry() time.Time {
+	if c.ExpiresAt == 0 {
+		return time.Time{}
+	}
+	return time.Unix(c.ExpiresAt, 0)
+}
+
+// authTime returns when the user authenticated, tokens generated before
+// auth_time was added use their iat
+func (c *Claims) authTime() time.Time {
+	if c.AuthTime != 0 {
+		return time.Unix(c.AuthTime, 0)
+	}
+	if c.IssuedAt != 0 {
+		return time.Unix(c.IssuedAt, 0)
+	}
+	return time.Now()
+}
+
+// ParseClaims decodes the claims of a token generated by Authenticator, a
+// ClaimError is returned if a claim is missing or has the wrong type
+func ParseClaims(token *jwt.Token) (*Claims, error) {
+	for _, name := range requiredClaims {
+		if _, ok := token.Claims[name]; !ok {
+			return nil, &ClaimError{Claim: name, Err: ErrClaimMissing}
+		}
+	}
+
+	claims := &Claims{}
+	if err := DecodeClaims(token, claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// DecodeClaims decodes the claims of a verified token into v, which can be
+// any type encoding/json can unmarshal into. It allows applications to parse
+// their own claim structs.
+func DecodeClaims(token *jwt.Token, v interface{}) error {
+	data, err := ffjson.Marshal(token.Claims)
+	if err != nil {
+		return err
+	}
+
+	if err := ffjson.Unmarshal(data, v); err != nil {
+		return claimsError(err)
+	}
+
+	return nil
+}
+
+// claimsError converts json type errors into a ClaimError naming the claim
+func claimsError(err error) error {
+	switch e := err.(type) {
+	case *ClaimError:
+		return e
+	case *json.UnmarshalTypeError:
+		claim := strings.SplitN(e.Field, ".", 2)[0]
+		return &ClaimError{Claim: claim, Err: ErrClaimInvalid}
+	}
+	return ErrTokenMalformed
+}
+
+// Audience is the aud claim, it is encoded as a string if it has a single
+// value and an array otherwise
+type Audience []string
+
+// MarshalJSON implements json.Marshaler
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*a = nil
+		return nil
+	}
+
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return &ClaimError{Claim: "aud", Err: ErrClaimInvalid}
+	}
+
+	*a = Audience(multiple)
+	return nil
+}
+
+// Contains checks if the audience contains any of the values
+func (a Audience) Contains(values ...string) bool {
+	for _, aud := range a {
+		for _, value := range values {
+			if aud == value {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gopkg.in/dgrijalva/jwt-go.v2"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestClaims(t *testing.T) {
+	if !testing.Verbose() {
+		t.Parallel()
+	}
+
+	Convey("Given claims with extra claims", t, func() {
+		claims := &Claims{
+			ID:          "jti",
+			Subject:     "uid",
+			Audience:    Audience{"service1"},
+			ExpiresAt:   100,
+			UID:         "uid",
+			Type:        "token",
+			Permissions: []string{"permission1"},
+			Extra:       map[string]interface{}{"tenant": "tenant1"},
+		}
+
+		Convey("When they are converted to a map", func() {
+			m, err := claims.Map()
+			So(err, ShouldBeNil)
+
+			Convey("Then the claims should be flattened", func() {
+				So(m["jti"], ShouldEqual, "jti")
+				So(m["aud"], ShouldEqual, "service1")
+				So(m["exp"], ShouldEqual, 100)
+				So(m["tenant"], ShouldEqual, "tenant1")
+				So(m, ShouldNotContainKey, "iss")
+			})
+
+			Convey("Then they should be parsed back", func() {
+				parsed, err := ParseClaims(&jwt.Token{Claims: m})
+				So(err, ShouldBeNil)
+				So(parsed, ShouldResemble, claims)
+			})
+		})
+
+		Convey("When an extra claim is reserved", func() {
+			claims.Extra["exp"] = 200
+
+			_, err := claims.Map()
+
+			Convey("Then ErrClaimReserved should be returned", func() {
+				So(errors.Is(err, ErrClaimReserved), ShouldBeTrue)
+			})
+		})
+	})
+
+	Convey("Given token claims", t, func() {
+		token := &jwt.Token{Claims: map[string]interface{}{
+			"uid":         "uid",
+			"type":        "token",
+			"permissions": []interface{}{"permission1"},
+		}}
+
+		Convey("When the audience is an array", func() {
+			token.Claims["aud"] = []interface{}{"service1", "service2"}
+
+			claims, err := ParseClaims(token)
+
+			Convey("Then every audience should be parsed", func() {
+				So(err, ShouldBeNil)
+				So(claims.Audience, ShouldResemble, Audience{"service1", "service2"})
+				So(claims.Audience.Contains("service2"), ShouldBeTrue)
+				So(claims.Audience.Contains("service3"), ShouldBeFalse)
+			})
+		})
+
+		Convey("When a permission is not a string", func() {
+			token.Claims["permissions"] = []interface{}{"permission1", 1}
+
+			_, err := ParseClaims(token)
+
+			Convey("Then a ClaimError for permissions should be returned", func() {
+				claimErr, ok := err.(*ClaimError)
+				So(ok, ShouldBeTrue)
+				So(claimErr.Claim, ShouldEqual, "permissions")
+				So(claimErr.Err, ShouldEqual, ErrClaimInvalid)
+			})
+		})
+
+		Convey("When the audience is not a string", func() {
+			token.Claims["aud"] = 1
+
+			_, err := ParseClaims(token)
+
+			Convey("Then a ClaimError for aud should be returned", func() {
+				claimErr, ok := err.(*ClaimError)
+				So(ok, ShouldBeTrue)
+				So(claimErr.Claim, ShouldEqual, "aud")
+			})
+		})
+
+		Convey("When a required claim is missing", func() {
+			delete(token.Claims, "type")
+
+			_, err := ParseClaims(token)
+
+			Convey("Then a ClaimError for the claim should be returned", func() {
+				claimErr, ok := err.(*ClaimError)
+				So(ok, ShouldBeTrue)
+				So(claimErr.Claim, ShouldEqual, "type")
+				So(claimErr.Err, ShouldEqual, ErrClaimMissing)
+			})
+		})
+	})
+}
+
+type mockAppClaims struct {
+	UID    string `json:"uid"`
+	Tenant string `json:"tenant"`
+}
+
+func TestDecodeClaims(t *testing.T) {
+	if !testing.Verbose() {
+		t.Parallel()
+	}
+
+	Convey("Given a token with custom claims", t, func() {
+		storage := &mockStorage{
+			UID:      "test_uid",
+			Username: "test_user",
+			Password: "test_pass",
+			Claims:   map[string]interface{}{"tenant": "tenant1"},
+		}
+		auth := NewAuthenticator(NewMockTokenGenerator(), storage, time.Hour, time.Hour)
+
+		token, _, err := auth.Authenticate("test_user", "test_pass")
+		So(err, ShouldBeNil)
+
+		Convey("When the token is validated into an application struct", func() {
+			claims := &mockAppClaims{}
+			user, err := auth.ValidateTokenClaims(token, claims)
+
+			Convey("Then the struct should be populated", func() {
+				So(err, ShouldBeNil)
+				So(user.UID, ShouldEqual, "test_uid")
+				So(claims.UID, ShouldEqual, "test_uid")
+				So(claims.Tenant, ShouldEqual, "tenant1")
+			})
+		})
+
+		Convey("When the claims do not match the application struct", func() {
+			claims := &struct {
+				Tenant int `json:"tenant"`
+			}{}
+			_, err := auth.ValidateTokenClaims(token, claims)
+
+			Convey("Then a ClaimError should be returned", func() {
+				claimErr, ok := err.(*ClaimError)
+				So(ok, ShouldBeTrue)
+				So(claimErr.Claim, ShouldEqual, "tenant")
+			})
+		})
+	})
+}
@@ -0,0 +1,278 @@
+package auth
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pquerna/ffjson/ffjson"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Errors returned from clients
+var (
+	ErrClientNotFound   = errors.New("Client not found")
+	ErrClientInvalid    = errors.New("Client authentication failed")
+	ErrGrantNotAllowed  = errors.New("Grant type is not allowed for the client")
+	ErrScopeNotAllowed  = errors.New("Scope is not allowed for the client")
+	ErrClientIDRequired = errors.New("Client ID is required")
+)
+
+// Client is an OAuth 2.0 client, such as another service. Clients without a
+// secret are public clients and can not use the client_credentials grant.
+type Client struct {
+	ID string
+
+	// SecretHash is the bcrypt hash of the client secret, see
+	// HashClientSecret
+	SecretHash string
+
+	// Grants are the grant types the client can use
+	Grants []string
+
+	// Scopes are the permissions tokens issued to the client can have
+	Scopes []string
+
+	// Lifetime overrides the lifetime of tokens issued to the client if it is
+	// not zero
+	Lifetime time.Duration
+}
+
+// HashClientSecret hashes a client secret for Client.SecretHash
+func HashClientSecret(secret string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// VerifySecret checks the secret of a confidential client
+func (c *Client) VerifySecret(secret string) bool {
+	if c.SecretHash == "" {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(c.SecretHash), []byte(secret)) == nil
+}
+
+// AllowsGrant checks if the client can use a grant type
+func (c *Client) AllowsGrant(grant string) bool {
+	for _, allowed := range c.Grants {
+		if allowed == grant {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsScope checks if tokens issued to the client can have a scope, scopes
+// are matched like permissions
+func (c *Client) AllowsScope(scope string) bool {
+	for _, allowed := range c.Scopes {
+		if permissionGrants(allowed, scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// limitPermissions returns the permissions that are granted by both the
+// permissions and the scopes, the narrower one is kept when they overlap
+func limitPermissions(permissions []string, scopes []string) []string {
+	limited := []string{}
+	for _, permission := range permissions {
+		for _, scope := range scopes {
+			switch {
+			case permissionGrants(scope, permission):
+				limited = appendUnique(limited, permission)
+			case permissionGrants(permission, scope):
+				limited = appendUnique(limited, scope)
+			}
+		}
+	}
+	return limited
+}
+
+// ClientRegistry stores OAuth 2.0 clients
+type ClientRegistry interface {
+	// Client returns a client, ErrClientNotFound is returned if the client
+	// does not exist
+	Client(id string) (*Client, error)
+}
+
+// MemoryClientRegistry is a ClientRegistry held in memory
+type MemoryClientRegistry struct {
+	mutex   sync.RWMutex
+	clients map[string]*Client
+}
+
+// NewMemoryClientRegistry creates a MemoryClientRegistry with clients
+func NewMemoryClientRegistry(clients ...*Client) *MemoryClientRegistry {
+	r := &MemoryClientRegistry{
+		clients: make(map[string]*Client),
+	}
+
+	for _, client := range clients {
+		r.clients[client.ID] = client
+	}
+
+	return r
+}
+
+// Client implements ClientRegistry
+func (r *MemoryClientRegistry) Client(id string) (*Client, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	client, ok := r.clients[id]
+	if !ok {
+		return nil, ErrClientNotFound
+	}
+
+	c := *client
+	return &c, nil
+}
+
+// Register adds or replaces a client
+func (r *MemoryClientRegistry) Register(client *Client) error {
+	if client.ID == "" {
+		return ErrClientIDRequired
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	c := *client
+	r.clients[client.ID] = &c
+
+	return nil
+}
+
+// Remove removes a client
+func (r *MemoryClientRegistry) Remove(id string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.clients, id)
+
+	return nil
+}
+
+// clientFile is the format of client registry files
+type clientFile struct {
+	Clients []clientJSON `json:"clients"`
+}
+
+type clientJSON struct {
+	ID         string   `json:"id"`
+	SecretHash string   `json:"secret_hash,omitempty"`
+	Grants     []string `json:"grants"`
+	Scopes     []string `json:"scopes"`
+
+	// Lifetime is in seconds
+	Lifetime int64 `json:"lifetime,omitempty"`
+}
+
+// FileClientRegistry is a MemoryClientRegistry that is saved to a file
+// whenever a client is registered or removed
+type FileClientRegistry struct {
+	*MemoryClientRegistry
+	path      string
+	saveMutex sync.Mutex
+}
+
+// NewFileClientRegistry creates a FileClientRegistry, clients are loaded from
+// the file if it exists
+//
+//	{"clients": [{
+//	    "id": "orders",
+//	    "secret_hash": "$2a$10$...",
+//	    "grants": ["client_credentials"],
+//	    "scopes": ["products:read"],
+//	    "lifetime": 300
+//	}]}
+func NewFileClientRegistry(path string) (*FileClientRegistry, error) {
+	registry := &FileClientRegistry{
+		MemoryClientRegistry: NewMemoryClientRegistry(),
+		path:                 path,
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return registry, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var file clientFile
+	if err := ffjson.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	for _, client := range file.Clients {
+		if client.ID == "" {
+			return nil, ErrClientIDRequired
+		}
+
+		registry.clients[client.ID] = &Client{
+			ID:         client.ID,
+			SecretHash: client.SecretHash,
+			Grants:     client.Grants,
+			Scopes:     client.Scopes,
+			Lifetime:   time.Duration(client.Lifetime) * time.Second,
+		}
+	}
+
+	return registry, nil
+}
+
+// Register adds or replaces a client
+func (r *FileClientRegistry) Register(client *Client) error {
+	if err := r.MemoryClientRegistry.Register(client); err != nil {
+		return err
+	}
+	return r.save()
+}
+
+// Remove removes a client
+func (r *FileClientRegistry) Remove(id string) error {
+	if err := r.MemoryClientRegistry.Remove(id); err != nil {
+		return err
+	}
+	return r.save()
+}
+
+// save writes the clients to the file
+func (r *FileClientRegistry) save() error {
+	r.saveMutex.Lock()
+	defer r.saveMutex.Unlock()
+
+	r.mutex.RLock()
+	file := clientFile{Clients: make([]clientJSON, 0, len(r.clients))}
+	for _, client := range r.clients {
+		file.Clients = append(file.Clients, clientJSON{
+			ID:         client.ID,
+			SecretHash: client.SecretHash,
+			Grants:     client.Grants,
+			Scopes:     client.Scopes,
+			Lifetime:   int64(client.Lifetime / time.Second),
+		})
+	}
+	r.mutex.RUnlock()
+
+	sort.Slice(file.Clients, func(i, j int) bool {
+		return file.Clients[i].ID < file.Clients[j].ID
+	})
+
+	data, err := ffjson.Marshal(&file)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(r.path, data)
+}
@@ -0,0 +1,554 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestClient(t *testing.T) {
+	if !testing.Verbose() {
+		t.Parallel()
+	}
+
+	Convey("Given a confidential client", t, func() {
+		hash, err := HashClientSecret("test_secret")
+		So(err, ShouldBeNil)
+
+		client := &Client{
+			ID:         "test_client",
+			SecretHash: hash,
+			Grants:     []string{"client_credentials"},
+			Scopes:     []string{"orders:read", "products:*"},
+		}
+
+		Convey("When its secret is verified", func() {
+			Convey("Then only the right secret should be accepted", func() {
+				So(hash, ShouldNotEqual, "test_secret")
+				So(client.VerifySecret("test_secret"), ShouldBeTrue)
+				So(client.VerifySecret("invalid"), ShouldBeFalse)
+				So(client.VerifySecret(""), ShouldBeFalse)
+			})
+		})
+
+		Convey("When its grants and scopes are checked", func() {
+			Convey("Then scopes should be matched like permissions", func() {
+				So(client.AllowsGrant("client_credentials"), ShouldBeTrue)
+				So(client.AllowsGrant("password"), ShouldBeFalse)
+				So(client.AllowsScope("orders:read"), ShouldBeTrue)
+				So(client.AllowsScope("products:write"), ShouldBeTrue)
+				So(client.AllowsScope("orders:write"), ShouldBeFalse)
+			})
+		})
+
+		Convey("When a public client verifies a secret", func() {
+			public := &Client{ID: "public"}
+
+			Convey("Then it should be rejected", func() {
+				So(public.VerifySecret(""), ShouldBeFalse)
+			})
+		})
+	})
+}
+
+func TestLimitPermissions(t *testing.T) {
+	if !testing.Verbose() {
+		t.Parallel()
+	}
+
+	Convey("Given permissions and scopes", t, func() {
+		permissions := []string{"orders:*", "products:read", "users"}
+		scopes := []string{"orders:read", "products", "invoices"}
+
+		Convey("When the permissions are limited", func() {
+			limited := limitPermissions(permissions, scopes)
+
+			Convey("Then only the narrower overlap should be kept", func() {
+				So(limited, ShouldResemble, []string{"orders:read", "products:read"})
+			})
+		})
+	})
+}
+
+func TestMemoryClientRegistry(t *testing.T) {
+	if !testing.Verbose() {
+		t.Parallel()
+	}
+
+	Convey("Given a MemoryClientRegistry", t, func() {
+		registry := NewMemoryClientRegistry(&Client{ID: "test_client", Scopes: []string{"orders:read"}})
+
+		Convey("When a client is looked up", func() {
+			client, err := registry.Client("test_client")
+			So(err, ShouldBeNil)
+
+			Convey("Then it should be a copy", func() {
+				client.Scopes = nil
+				again, err := registry.Client("test_client")
+				So(err, ShouldBeNil)
+				So(again.Scopes, ShouldResemble, []string{"orders:read"})
+			})
+		})
+
+		Convey("When clients are registered and removed", func() {
+			So(registry.Register(&Client{ID: "other"}), ShouldBeNil)
+			So(registry.Remove("test_client"), ShouldBeNil)
+
+			Convey("Then the registry should be updated", func() {
+				_, err := registry.Client("other")
+				So(err, ShouldBeNil)
+
+				_, err = registry.Client("test_client")
+				So(err, ShouldEqual, ErrClientNotFound)
+			})
+		})
+
+		Convey("When a client without an ID is registered", func() {
+			err := registry.Register(&Client{})
+
+			Convey("Then ErrClientIDRequired should be returned", func() {
+				So(err, ShouldEqual, ErrClientIDRequired)
+			})
+		})
+	})
+}
+
+func TestFileClientRegistry(t *testing.T) {
+	if !testing.Verbose() {
+		t.Parallel()
+	}
+
+	Convey("Given a FileClientRegistry", t, func() {
+		dir, err := ioutil.TempDir("", "auth-clients")
+		So(err, ShouldBeNil)
+
+		Reset(func() {
+			os.RemoveAll(dir)
+		})
+
+		path := filepath.Join(dir, "clients.json")
+		registry, err := NewFileClientRegistry(path)
+		So(err, ShouldBeNil)
+
+		Convey("When a client is registered", func() {
+			So(registry.Register(&Client{
+				ID:         "test_client",
+				SecretHash: "hash",
+				Grants:     []string{"client_credentials"},
+				Scopes:     []string{"orders:read"},
+				Lifetime:   5 * time.Minute,
+			}), ShouldBeNil)
+
+			Convey("Then it should be loaded by a new registry", func() {
+				loaded, err := NewFileClientRegistry(path)
+				So(err, ShouldBeNil)
+
+				client, err := loaded.Client("test_client")
+				So(err, ShouldBeNil)
+				So(client, ShouldResemble, &Client{
+					ID:         "test_client",
+					SecretHash: "hash",
+					Grants:     []string{"client_credentials"},
+					Scopes:     []string{"orders:read"},
+					Lifetime:   5 * time.Minute,
+				})
+			})
+
+			Convey("Then it should be removed from the file when it is removed", func() {
+				So(registry.Remove("test_client"), ShouldBeNil)
+
+				loaded, err := NewFileClientRegistry(path)
+				So(err, ShouldBeNil)
+
+				_, err = loaded.Client("test_client")
+				So(err, ShouldEqual, ErrClientNotFound)
+			})
+		})
+
+		Convey("When the file is invalid", func() {
+			So(ioutil.WriteFile(path, []byte(`{"clients": [{"scopes": []}]}`), 0600), ShouldBeNil)
+			_, err := NewFileClientRegistry(path)
+
+			Convey("Then an error should be returned", func() {
+				So(err, ShouldEqual, ErrClientIDRequired)
+			})
+		})
+	})
+}
+
+func TestHandlerClientCredentials(t *testing.T) {
+	if !testing.Verbose() {
+		t.Parallel()
+	}
+
+	hash, err := HashClientSecret("test_secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Convey("Given a token endpoint with clients", t, func() {
+		storage := NewMockStorage("test_uid", "test_user", "test_pass", []string{"orders:read", "orders:write"})
+		auth := NewAuthenticator(NewMockTokenGenerator(), storage, time.Hour, time.Hour*24)
+		registry := NewMemoryClientRegistry(
+			&Client{
+				ID:         "test_client",
+				SecretHash: hash,
+				Grants:     []string{"client_credentials"},
+				Scopes:     []string{"orders:read", "products:*"},
+				Lifetime:   5 * time.Minute,
+			},
+			&Client{
+				ID:     "public_client",
+				Grants: []string{"password", "refresh_token", "client_credentials"},
+				Scopes: []string{"orders:*"},
+			},
+			&Client{
+				ID:         "web_client",
+				SecretHash: hash,
+				Grants:     []string{"password", "refresh_token"},
+				Scopes:     []string{"orders:read"},
+			},
+		)
+		handler := NewHandler(auth, WithClients(registry))
+
+		post := func(values url.Values, id string, secret string) (*httptest.ResponseRecorder, map[string]interface{}) {
+			r := httptest.NewRequest("POST", "/token", strings.NewReader(values.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if id != "" {
+				r.SetBasicAuth(url.QueryEscape(id), url.QueryEscape(secret))
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			body := make(map[string]interface{})
+			So(json.NewDecoder(w.Body).Decode(&body), ShouldBeNil)
+			return w, body
+		}
+
+		Convey("When a client authenticates with HTTP Basic", func() {
+			w, body := post(url.Values{"grant_type": []string{"client_credentials"}}, "test_client", "test_secret")
+
+			Convey("Then a token with all of its scopes should be returned", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(body["refresh_token"], ShouldBeNil)
+				So(body["scope"], ShouldEqual, "orders:read products:*")
+				So(body["expires_in"], ShouldAlmostEqual, 300, 1)
+			})
+
+			Convey("Then the token should carry the client ID", func() {
+				user, err := auth.ValidateToken(body["access_token"].(string))
+				So(err, ShouldBeNil)
+				So(user.UID, ShouldBeEmpty)
+				So(user.ClientID, ShouldEqual, "test_client")
+				So(user.IsClient(), ShouldBeTrue)
+				So(user.Permissions, ShouldResemble, []string{"orders:read", "products:*"})
+			})
+		})
+
+		Convey("When a client is named like an existing user", func() {
+			So(registry.Register(&Client{
+				ID:         "test_uid",
+				SecretHash: hash,
+				Grants:     []string{"client_credentials"},
+				Scopes:     []string{"orders:read"},
+			}), ShouldBeNil)
+
+			w, body := post(url.Values{"grant_type": []string{"client_credentials"}}, "test_uid", "test_secret")
+			So(w.Code, ShouldEqual, http.StatusOK)
+			token := body["access_token"].(string)
+
+			var user *User
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				user, _ = UserFromContext(r.Context())
+			})
+
+			Convey("Then its token should not act as the user", func() {
+				validated, err := auth.ValidateToken(token)
+				So(err, ShouldBeNil)
+				So(validated.UID, ShouldBeEmpty)
+				So(validated.ClientID, ShouldEqual, "test_uid")
+			})
+
+			Convey("Then the middleware should refuse it by default", func() {
+				w := httptest.NewRecorder()
+				handler.Middleware(next).ServeHTTP(w, bearerRequest(token))
+
+				So(w.Code, ShouldEqual, http.StatusUnauthorized)
+				So(w.Header().Get("WWW-Authenticate"), ShouldStartWith, `Bearer error="invalid_token"`)
+				So(user, ShouldBeNil)
+			})
+
+			Convey("Then the middleware should pass it on as a client when client tokens are accepted", func() {
+				accepting := NewHandler(auth, WithClientTokens())
+				w := httptest.NewRecorder()
+				accepting.Middleware(next).ServeHTTP(w, bearerRequest(token))
+
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(user.IsClient(), ShouldBeTrue)
+				So(user.UID, ShouldBeEmpty)
+			})
+
+			Convey("Then revoking the user should not affect it", func() {
+				auth.revocations = NewMemoryRevocationStore(time.Hour)
+				So(auth.RevokeUser("test_uid"), ShouldBeNil)
+
+				_, err := auth.ValidateToken(token)
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When a client authenticates with client_secret_post and requests scopes", func() {
+			w, body := post(url.Values{
+				"grant_type":    []string{"client_credentials"},
+				"client_id":     []string{"test_client"},
+				"client_secret": []string{"test_secret"},
+				"scope":         []string{"products:read"},
+			}, "", "")
+
+			Convey("Then a token with the requested scopes should be returned", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(body["scope"], ShouldEqual, "products:read")
+			})
+		})
+
+		Convey("When a client requests a scope it is not allowed", func() {
+			w, body := post(url.Values{
+				"grant_type": []string{"client_credentials"},
+				"scope":      []string{"orders:write"},
+			}, "test_client", "test_secret")
+
+			Convey("Then invalid_scope should be returned", func() {
+				So(w.Code, ShouldEqual, http.StatusBadRequest)
+				So(body["error"], ShouldEqual, "invalid_scope")
+			})
+		})
+
+		Convey("When a client sends an invalid secret", func() {
+			w, body := post(url.Values{"grant_type": []string{"client_credentials"}}, "test_client", "invalid")
+
+			Convey("Then invalid_client should be returned with a challenge", func() {
+				So(w.Code, ShouldEqual, http.StatusUnauthorized)
+				So(w.Header().Get("WWW-Authenticate"), ShouldStartWith, "Basic")
+				So(body["error"], ShouldEqual, "invalid_client")
+			})
+		})
+
+		Convey("When an unknown client authenticates", func() {
+			w, body := post(url.Values{"grant_type": []string{"client_credentials"}}, "unknown", "test_secret")
+
+			Convey("Then invalid_client should be returned", func() {
+				So(w.Code, ShouldEqual, http.StatusUnauthorized)
+				So(body["error"], ShouldEqual, "invalid_client")
+			})
+		})
+
+		Convey("When client credentials are sent twice", func() {
+			w, body := post(url.Values{
+				"grant_type": []string{"client_credentials"},
+				"client_id":  []string{"test_client"},
+			}, "test_client", "test_secret")
+
+			Convey("Then invalid_request should be returned", func() {
+				So(w.Code, ShouldEqual, http.StatusBadRequest)
+				So(body["error"], ShouldEqual, "invalid_request")
+			})
+		})
+
+		Convey("When a client uses a grant it is not allowed", func() {
+			w, body := post(url.Values{
+				"grant_type": []string{"password"},
+				"username":   []string{"test_user"},
+				"password":   []string{"test_pass"},
+			}, "test_client", "test_secret")
+
+			Convey("Then unauthorized_client should be returned", func() {
+				So(w.Code, ShouldEqual, http.StatusBadRequest)
+				So(body["error"], ShouldEqual, "unauthorized_client")
+			})
+		})
+
+		Convey("When a public client requests client_credentials", func() {
+			w, body := post(url.Values{
+				"grant_type": []string{"client_credentials"},
+				"client_id":  []string{"public_client"},
+			}, "", "")
+
+			Convey("Then invalid_client should be returned", func() {
+				So(w.Code, ShouldEqual, http.StatusUnauthorized)
+				So(body["error"], ShouldEqual, "invalid_client")
+			})
+		})
+
+		Convey("When a public client uses the password grant", func() {
+			w, body := post(url.Values{
+				"grant_type": []string{"password"},
+				"client_id":  []string{"public_client"},
+				"username":   []string{"test_user"},
+				"password":   []string{"test_pass"},
+			}, "", "")
+
+			Convey("Then a token should be returned", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(body["access_token"], ShouldNotBeEmpty)
+			})
+		})
+
+		Convey("When a client uses the password grant", func() {
+			w, body := post(url.Values{
+				"grant_type": []string{"password"},
+				"username":   []string{"test_user"},
+				"password":   []string{"test_pass"},
+			}, "web_client", "test_secret")
+			So(w.Code, ShouldEqual, http.StatusOK)
+			refresh := url.Values{
+				"grant_type":    []string{"refresh_token"},
+				"refresh_token": []string{body["refresh_token"].(string)},
+			}
+
+			Convey("Then the permissions should be limited to its scopes", func() {
+				So(body["scope"], ShouldEqual, "orders:read")
+
+				user, err := auth.ValidateToken(body["access_token"].(string))
+				So(err, ShouldBeNil)
+				So(user.UID, ShouldEqual, "test_uid")
+				So(user.ClientID, ShouldEqual, "web_client")
+				So(user.IsClient(), ShouldBeFalse)
+			})
+
+			Convey("Then the refresh token should be usable by the client", func() {
+				w, refreshed := post(refresh, "web_client", "test_secret")
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(refreshed["scope"], ShouldEqual, "orders:read")
+			})
+
+			Convey("Then the refresh token should be refused for another client", func() {
+				registry.Register(&Client{ID: "other_client", SecretHash: hash, Grants: []string{"refresh_token"}})
+				w, refreshed := post(refresh, "other_client", "test_secret")
+				So(w.Code, ShouldEqual, http.StatusBadRequest)
+				So(refreshed["error"], ShouldEqual, "invalid_grant")
+			})
+
+			Convey("Then the refresh token should be refused without client credentials", func() {
+				w, refreshed := post(refresh, "", "")
+				So(w.Code, ShouldEqual, http.StatusBadRequest)
+				So(refreshed["error"], ShouldEqual, "invalid_grant")
+			})
+		})
+
+		Convey("When a password grant has no client credentials", func() {
+			w, _ := post(url.Values{
+				"grant_type": []string{"password"},
+				"username":   []string{"test_user"},
+				"password":   []string{"test_pass"},
+			}, "", "")
+
+			Convey("Then a token should be returned", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+			})
+		})
+
+		Convey("When client_credentials is requested without clients", func() {
+			handler = NewHandler(auth, WithOAuth2())
+			w, body := post(url.Values{"grant_type": []string{"client_credentials"}}, "test_client", "test_secret")
+
+			Convey("Then unsupported_grant_type should be returned", func() {
+				So(w.Code, ShouldEqual, http.StatusBadRequest)
+				So(body["error"], ShouldEqual, "unsupported_grant_type")
+			})
+		})
+	})
+}
+
+func TestHandlerClientRevocation(t *testing.T) {
+	if !testing.Verbose() {
+		t.Parallel()
+	}
+
+	hash, err := HashClientSecret("test_secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Convey("Given a revocation endpoint with clients", t, func() {
+		storage := NewMockStorage("test_uid", "test_user", "test_pass", []string{"orders:read"})
+		auth := NewAuthenticator(NewMockTokenGenerator(), storage, time.Hour, time.Hour*24,
+			WithRevocationStore(NewMemoryRevocationStore(time.Hour)),
+		)
+		client := &Client{ID: "test_client", SecretHash: hash, Grants: []string{"client_credentials"}}
+		other := &Client{ID: "other_client", SecretHash: hash, Grants: []string{"client_credentials"}}
+		handler := NewHandler(auth, WithClients(NewMemoryClientRegistry(client, other)))
+
+		token, err := auth.GenerateClient(context.Background(), client, []string{"orders:read"})
+		So(err, ShouldBeNil)
+
+		revoke := func(id string, secret string) *httptest.ResponseRecorder {
+			values := url.Values{"token": []string{token}}
+			r := httptest.NewRequest("POST", "/revoke", strings.NewReader(values.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if id != "" {
+				r.SetBasicAuth(url.QueryEscape(id), url.QueryEscape(secret))
+			}
+
+			w := httptest.NewRecorder()
+			handler.RevokeHandler().ServeHTTP(w, r)
+			return w
+		}
+
+		Convey("When the client revokes its own token", func() {
+			w := revoke("test_client", "test_secret")
+
+			Convey("Then the token should be revoked", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+
+				_, err := auth.ValidateToken(token)
+				So(err, ShouldEqual, ErrTokenRevoked)
+			})
+		})
+
+		Convey("When another client revokes the token", func() {
+			w := revoke("other_client", "test_secret")
+
+			Convey("Then the request should be refused", func() {
+				So(w.Code, ShouldEqual, http.StatusBadRequest)
+				So(w.Body.String(), ShouldContainSubstring, `"invalid_grant"`)
+
+				_, err := auth.ValidateToken(token)
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When the token is revoked without client credentials", func() {
+			w := revoke("", "")
+
+			Convey("Then the request should be refused", func() {
+				So(w.Code, ShouldEqual, http.StatusBadRequest)
+
+				_, err := auth.ValidateToken(token)
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When the client sends an invalid secret", func() {
+			w := revoke("test_client", "wrong")
+
+			Convey("Then invalid_client should be returned", func() {
+				So(w.Code, ShouldEqual, http.StatusUnauthorized)
+				So(w.Body.String(), ShouldContainSubstring, `"invalid_client"`)
+
+				_, err := auth.ValidateToken(token)
+				So(err, ShouldBeNil)
+			})
+		})
+	})
+}
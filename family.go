@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Errors returned from FamilyStore
+var (
+	ErrRefreshTokenReused = errors.New("Refresh token has already been used")
+	ErrFamilyExists       = errors.New("Refresh token family already exists")
+)
+
+// FamilyStore stores refresh token families. Each refresh creates a new
+// refresh token in the same family and only the newest can be used, if an
+// older one is presented it has been stolen or replayed so the whole family
+// is revoked.
+type FamilyStore interface {
+	// Create creates a family whose current refresh token is jti, expires is
+	// when the refresh token expires and can be zero. ErrFamilyExists is
+	// returned if the family already exists.
+	Create(family string, jti string, expires time.Time) error
+
+	// Check returns nil if jti is the current refresh token of the family. If
+	// jti has already been used the family is revoked and
+	// ErrRefreshTokenReused is returned, if the family is revoked or unknown
+	// ErrTokenRevoked is returned.
+	Check(family string, jti string) error
+
+	// Rotate checks jti like Check and replaces it with next as the current
+	// refresh token of the family
+	Rotate(family string, jti string, next string, expires time.Time) error
+
+	// Revoke revokes a family
+	Revoke(family string) error
+}
+
+type familyEntry struct {
+	current string
+	revoked bool
+	expires time.Time
+}
+
+// MemoryFamilyStore is a FamilyStore held in memory, families are pruned once
+// their refresh token expires
+type MemoryFamilyStore struct {
+	ttl      time.Duration
+	mutex    sync.Mutex
+	families map[string]*familyEntry
+}
+
+// NewMemoryFamilyStore creates a MemoryFamilyStore, ttl is how long families
+// without an expiry are kept
+func NewMemoryFamilyStore(ttl time.Duration) *MemoryFamilyStore {
+	return &MemoryFamilyStore{
+		ttl:      ttl,
+		families: make(map[string]*familyEntry),
+	}
+}
+
+// Create implements FamilyStore
+func (s *MemoryFamilyStore) Create(family string, jti string, expires time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.prune(time.Now())
+
+	if _, ok := s.families[family]; ok {
+		return ErrFamilyExists
+	}
+
+	s.families[family] = &familyEntry{
+		current: jti,
+		expires: s.expires(expires),
+	}
+
+	return nil
+}
+
+// Check implements FamilyStore
+func (s *MemoryFamilyStore) Check(family string, jti string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, err := s.check(family, jti)
+	return err
+}
+
+// Rotate implements FamilyStore
+func (s *MemoryFamilyStore) Rotate(family string, jti string, next string, expires time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entry, err := s.check(family, jti)
+	if err != nil {
+		return err
+	}
+
+	entry.current = next
+	entry.expires = s.expires(expires)
+
+	return nil
+}
+
+// Revoke implements FamilyStore
+func (s *MemoryFamilyStore) Revoke(family string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if entry, ok := s.families[family]; ok {
+		entry.revoked = true
+	}
+
+	return nil
+}
+
+// Prune removes expired families
+func (s *MemoryFamilyStore) Prune() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.prune(time.Now())
+}
+
+func (s *MemoryFamilyStore) check(family string, jti string) (*familyEntry, error) {
+	entry, ok := s.families[family]
+	if !ok || entry.revoked {
+		return nil, ErrTokenRevoked
+	}
+
+	if entry.current != jti {
+		entry.revoked = true
+		return nil, ErrRefreshTokenReused
+	}
+
+	return entry, nil
+}
+
+func (s *MemoryFamilyStore) expires(expires time.Time) time.Time {
+	if expires.IsZero() {
+		return time.Now().Add(s.ttl)
+	}
+	return expires
+}
+
+func (s *MemoryFamilyStore) prune(now time.Time) {
+	for family, entry := range s.families {
+		if now.After(entry.expires) {
+			delete(s.families, family)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMemoryFamilyStore(t *testing.T) {
+	if !testing.Verbose() {
+		t.Parallel()
+	}
+
+	Convey("Given a memory family store with a family", t, func() {
+		store := NewMemoryFamilyStore(time.Hour)
+		So(store.Create("family", "jti1", time.Now().Add(time.Hour)), ShouldBeNil)
+
+		Convey("When the current refresh token is checked", func() {
+			err := store.Check("family", "jti1")
+
+			Convey("Then it should be valid", func() {
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When the family is created again", func() {
+			err := store.Create("family", "jti2", time.Time{})
+
+			Convey("Then ErrFamilyExists should be returned", func() {
+				So(err, ShouldEqual, ErrFamilyExists)
+			})
+		})
+
+		Convey("When the family is rotated", func() {
+			So(store.Rotate("family", "jti1", "jti2", time.Now().Add(time.Hour)), ShouldBeNil)
+
+			Convey("Then the new refresh token should be valid", func() {
+				So(store.Check("family", "jti2"), ShouldBeNil)
+			})
+
+			Convey("Then reusing the old refresh token should revoke the family", func() {
+				So(store.Check("family", "jti1"), ShouldEqual, ErrRefreshTokenReused)
+				So(store.Check("family", "jti2"), ShouldEqual, ErrTokenRevoked)
+			})
+
+			Convey("Then rotating the old refresh token should revoke the family", func() {
+				So(store.Rotate("family", "jti1", "jti3", time.Time{}), ShouldEqual, ErrRefreshTokenReused)
+				So(store.Check("family", "jti2"), ShouldEqual, ErrTokenRevoked)
+				So(store.Check("family", "jti3"), ShouldEqual, ErrTokenRevoked)
+			})
+		})
+
+		Convey("When the family is revoked", func() {
+			So(store.Revoke("family"), ShouldBeNil)
+
+			Convey("Then the refresh token should be revoked", func() {
+				So(store.Check("family", "jti1"), ShouldEqual, ErrTokenRevoked)
+			})
+		})
+
+		Convey("When an unknown family is checked", func() {
+			err := store.Check("unknown", "jti1")
+
+			Convey("Then ErrTokenRevoked should be returned", func() {
+				So(err, ShouldEqual, ErrTokenRevoked)
+			})
+		})
+
+		Convey("When the family expires", func() {
+			So(store.Create("expired", "jti1", time.Now().Add(-time.Second)), ShouldBeNil)
+			store.Prune()
+
+			Convey("Then it should be pruned", func() {
+				So(store.families, ShouldNotContainKey, "expired")
+				So(store.families, ShouldContainKey, "family")
+			})
+		})
+	})
+}
+
+func TestAuthenticatorRefreshRotation(t *testing.T) {
+	if !testing.Verbose() {
+		t.Parallel()
+	}
+
+	Convey("Given an Authenticator with refresh token rotation", t, func() {
+		generator := NewMockTokenGenerator()
+		storage := NewMockStorage("test_uid", "test_user", "test_pass", []string{"permission1"})
+		auth := NewAuthenticator(generator, storage, time.Hour, time.Hour,
+			WithFamilyStore(NewMemoryFamilyStore(time.Hour)),
+		)
+
+		_, refresh1, err := auth.Authenticate("test_user", "test_pass")
+		So(err, ShouldBeNil)
+
+		Convey("When the refresh token is used", func() {
+			token, refresh2, err := auth.Refresh(refresh1)
+			So(err, ShouldBeNil)
+
+			Convey("Then a new token should be generated for the user", func() {
+				user, err := auth.ValidateToken(token)
+				So(err, ShouldBeNil)
+				So(user.UID, ShouldEqual, "test_uid")
+				So(user.Permissions, ShouldResemble, []string{"permission1"})
+			})
+
+			Convey("Then the new refresh token should be valid", func() {
+				user, err := auth.ValidateRefresh(refresh2)
+				So(err, ShouldBeNil)
+				So(user.UID, ShouldEqual, "test_uid")
+
+				_, _, err = auth.Refresh(refresh2)
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then the old refresh token should be rejected", func() {
+				_, _, err := auth.Refresh(refresh1)
+				So(err, ShouldEqual, ErrRefreshTokenReused)
+				So(ErrorCode(err), ShouldEqual, CodeRefreshTokenReused)
+
+				Convey("And the whole family should be revoked", func() {
+					_, _, err := auth.Refresh(refresh2)
+					So(err, ShouldEqual, ErrTokenRevoked)
+
+					_, err = auth.ValidateRefresh(refresh2)
+					So(err, ShouldEqual, ErrTokenRevoked)
+				})
+			})
+		})
+
+		Convey("When signing fails during a refresh", func() {
+			signer := &contextSigner{secret: []byte("01234567890123456789012345678901")}
+			signerGenerator, err := NewSignerTokenGenerator(signer)
+			So(err, ShouldBeNil)
+			auth.generator = signerGenerator
+			auth.verifier = signerGenerator
+
+			_, refresh, err := auth.Authenticate("test_user", "test_pass")
+			So(err, ShouldBeNil)
+
+			signer.err = errors.New("hsm timeout")
+			_, _, err = auth.Refresh(refresh)
+			So(err, ShouldEqual, signer.err)
+
+			Convey("Then the refresh token should still be usable", func() {
+				signer.err = nil
+
+				_, next, err := auth.Refresh(refresh)
+				So(err, ShouldBeNil)
+
+				_, err = auth.ValidateRefresh(next)
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When another login is made", func() {
+			_, other, err := auth.Authenticate("test_user", "test_pass")
+			So(err, ShouldBeNil)
+
+			Convey("Then it should be a separate family", func() {
+				parsed1, err := generator.Verify(refresh1)
+				So(err, ShouldBeNil)
+
+				parsed2, err := generator.Verify(other)
+				So(err, ShouldBeNil)
+
+				So(parsed1.Claims["fam"], ShouldNotBeEmpty)
+				So(parsed2.Claims["fam"], ShouldNotBeEmpty)
+				So(parsed1.Claims["fam"], ShouldNotEqual, parsed2.Claims["fam"])
+			})
+		})
+
+		Convey("When the refresh token is revoked", func() {
+			So(auth.Revoke(refresh1), ShouldBeNil)
+
+			Convey("Then the family should be revoked", func() {
+				_, _, err := auth.Refresh(refresh1)
+				So(err, ShouldEqual, ErrTokenRevoked)
+			})
+		})
+
+		Convey("When an access token is revoked without a revocation store", func() {
+			token, _, err := auth.Authenticate("test_user", "test_pass")
+			So(err, ShouldBeNil)
+
+			err = auth.Revoke(token)
+
+			Convey("Then ErrRevocationUnsupported should be returned", func() {
+				So(err, ShouldEqual, ErrRevocationUnsupported)
+			})
+		})
+	})
+}
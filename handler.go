@@ -1,28 +1,72 @@
 package auth
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"time"
 
 	"github.com/pquerna/ffjson/ffjson"
-
-	"golang.org/x/net/context"
 )
 
+// contextKey is the type of context keys, it is unexported so keys can not
+// collide with other packages
+type contextKey int
+
 const (
-	contextKey = "uid"
+	userKey contextKey = iota
+)
+
+// Errors returned from Handler
+var (
+	ErrMethodNotAllowed   = errors.New("Method is not allowed")
+	ErrCredentialsMissing = errors.New("Username and password are required")
+	ErrCredentialsInQuery = errors.New("Credentials must be sent in the request body")
 )
 
 // Handler is a login handler
 type Handler struct {
-	auth *Authenticator
+	auth    *Authenticator
+	sources TokenSource
+	legacy  bool
+	oauth2  bool
+	clients ClientRegistry
+
+	// clientTokens allows client tokens in Middleware
+	clientTokens bool
+}
+
+// HandlerOption configures a Handler
+type HandlerOption func(*Handler)
+
+// WithTokenSources sets how tokens can be sent to the handler, the default is
+// DefaultTokenSources
+func WithTokenSources(sources TokenSource) HandlerOption {
+	return func(h *Handler) {
+		h.sources = sources
+	}
+}
+
+// WithLegacyResponses makes the handler respond to logins like older versions,
+// every response has a 200 status and any method is accepted
+func WithLegacyResponses() HandlerOption {
+	return func(h *Handler) {
+		h.legacy = true
+	}
 }
 
 // NewHandler creates a new login handler
-func NewHandler(auth *Authenticator) *Handler {
-	return &Handler{
-		auth: auth,
+func NewHandler(auth *Authenticator, options ...HandlerOption) *Handler {
+	h := &Handler{
+		auth:    auth,
+		sources: DefaultTokenSources,
+	}
+
+	for _, option := range options {
+		option(h)
 	}
+
+	return h
 }
 
 // NewHandlerAndAuthenticator creates a new login handler and Authenticator
@@ -32,78 +76,186 @@ func NewHandlerAndAuthenticator(method SigningMethod, storage Storage, lifetime
 	return NewHandler(auth), auth
 }
 
-// CtxServeHTTP implements scaffold.Handler
+// CtxServeHTTP implements scaffold.Handler, the context replaces the request
+// context.
+//
+// Deprecated: Use ServeHTTP, the context is taken from the request.
 func (h *Handler) CtxServeHTTP(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if ctx != nil {
+		r = r.WithContext(ctx)
+	}
+	h.ServeHTTP(w, r)
+}
+
+// ServeHTTP implements http.Handler, logins must be POST requests with the
+// credentials or refresh token in the body
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.oauth2 {
+		h.serveToken(w, r)
+		return
+	}
+
 	response := &Response{}
+	status := h.login(r, response)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+
+	if h.legacy {
+		status = http.StatusOK
+	} else if status == http.StatusMethodNotAllowed {
+		w.Header().Set("Allow", "POST")
+	}
 
-	defer func() {
-		encoder := ffjson.NewEncoder(w)
-		encoder.Encode(response)
-	}()
+	w.WriteHeader(status)
 
-	req, err := ParseRequest(r)
+	encoder := ffjson.NewEncoder(w)
+	encoder.Encode(response)
+}
+
+// login handles a login request and returns the response status
+func (h *Handler) login(r *http.Request, response *Response) int {
+	if r.Method != "POST" && !h.legacy {
+		response.setError(ErrMethodNotAllowed)
+		return http.StatusMethodNotAllowed
+	}
+
+	// Credentials in URLs end up in logs
+	query := r.URL.Query()
+	if !h.legacy && (query.Get("password") != "" || query.Get("refresh_token") != "") {
+		response.setError(ErrCredentialsInQuery)
+		return http.StatusBadRequest
+	}
+
+	req, err := ParseRequestSources(r, h.sources)
 	if err != nil {
 		response.Error = err.Error()
-		return
+		response.Code = CodeRequestInvalid
+		return http.StatusBadRequest
 	}
 
 	var token, refresh string
 
 	if req.Refresh != "" {
-		var user *User
-		user, err = h.auth.ValidateRefresh(req.Refresh)
-		if err != nil {
-			response.Error = err.Error()
-			return
-		}
-		token, err = h.auth.Generate(user)
-		if err != nil {
-			response.Error = err.Error()
-			return
-		}
-		refresh, err = h.auth.GenerateRefresh(user)
-		if err != nil {
-			response.Error = err.Error()
-			return
-		}
+		token, refresh, err = h.auth.RefreshClient(r.Context(), req.Refresh, nil)
+	} else if req.Username == "" || req.Password == "" {
+		response.setError(ErrCredentialsMissing)
+		return http.StatusBadRequest
 	} else {
-		token, refresh, err = h.auth.Authenticate(req.Username, req.Password)
-		if err != nil {
-			response.Error = err.Error()
-			return
-		}
+		token, refresh, err = h.auth.AuthenticateContext(r.Context(), r, req.Username, req.Password)
+	}
+
+	if err != nil {
+		response.setError(err)
+		return loginStatus(err)
 	}
 
 	response.Token = token
 	response.RefreshToken = refresh
+	return http.StatusOK
 }
 
-// ServeHTTP implements http.Handler
-func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	h.CtxServeHTTP(nil, w, r)
+// loginStatus returns the status of a failed login, errors from storage are
+// treated as invalid credentials
+func loginStatus(err error) int {
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, ErrCannotSign), errors.Is(err, ErrClaimReserved):
+		return http.StatusInternalServerError
+	}
+	return http.StatusUnauthorized
+}
+
+// oauthError is an error response defined by the OAuth 2.0 RFCs
+type oauthError struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func writeOAuthError(w http.ResponseWriter, status int, code string, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(status)
+
+	encoder := ffjson.NewEncoder(w)
+	encoder.Encode(&oauthError{
+		Error:       code,
+		Description: description,
+	})
+}
+
+// RevokeHandler returns a token revocation endpoint compatible with RFC 7009,
+// the token is read from the token form value. If the handler has clients,
+// see WithClients, the client is authenticated and can only revoke its own
+// tokens.
+func (h *Handler) RevokeHandler() http.Handler {
+	return http.HandlerFunc(h.serveRevoke)
+}
+
+func (h *Handler) serveRevoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		writeOAuthError(w, http.StatusMethodNotAllowed, oauthInvalidRequest, "Revocation requests must use POST")
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidRequest, "The request body is invalid")
+		return
+	}
+
+	token := r.PostForm.Get("token")
+	if token == "" {
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidRequest, "The token parameter is required")
+		return
+	}
+
+	// RFC 7009 section 2.1, clients can only revoke their own tokens
+	client, ok := h.authenticateClient(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.auth.RevokeClient(token, client)
+	switch {
+	case err == ErrTokenClientInvalid:
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidGrant, err.Error())
+		return
+	case err == ErrRevocationUnsupported:
+		writeOAuthError(w, http.StatusBadRequest, "unsupported_token_type", err.Error())
+		return
+	case err != nil && ErrorCode(err) == CodeError:
+		// The store failed, the client should retry
+		writeOAuthError(w, http.StatusServiceUnavailable, oauthServerError, "")
+		return
+	}
+
+	// Invalid tokens do not cause an error response, the client can not do
+	// anything about them
+	w.WriteHeader(http.StatusOK)
 }
 
 // UserFromRequest parses the UID from the request
 func (h *Handler) UserFromRequest(r *http.Request) (*User, error) {
-	req, err := ParseRequest(r)
+	req, err := ParseRequestSources(r, h.sources)
 	if err != nil {
 		return nil, err
 	}
 
-	user, err := h.auth.ValidateToken(req.Token)
-
-	return user, nil
+	return h.auth.ValidateToken(req.Token)
 }
 
-// UserFromContext get the uid of the context
-func UserFromContext(ctx context.Context) *User {
-	if user, ok := ctx.Value(contextKey).(*User); ok {
-		return user
-	}
-	return nil
+// UserFromContext gets the user stored in the context, ok is false if there is
+// no user
+func UserFromContext(ctx context.Context) (user *User, ok bool) {
+	user, ok = ctx.Value(userKey).(*User)
+	return user, ok && user != nil
 }
 
 // NewUserContext stores a user information in the context
 func NewUserContext(ctx context.Context, user *User) context.Context {
-	return context.WithValue(ctx, contextKey, user)
+	return context.WithValue(ctx, userKey, user)
 }
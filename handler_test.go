@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -10,8 +11,6 @@ import (
 	"testing"
 	"time"
 
-	"golang.org/x/net/context"
-
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -82,6 +81,7 @@ func TestHandler(t *testing.T) {
 				So(body, ShouldContainKey, "error")
 				So(body, ShouldNotContainKey, "token")
 				So(body["error"], ShouldNotBeEmpty)
+				So(body["code"], ShouldEqual, CodeTokenMalformed)
 			})
 		})
 
@@ -135,6 +135,7 @@ func TestHandler(t *testing.T) {
 				So(body, ShouldContainKey, "error")
 				So(body, ShouldNotContainKey, "token")
 				So(body["error"], ShouldNotBeEmpty)
+				So(body["code"], ShouldEqual, CodeRequestInvalid)
 			})
 		})
 	})
@@ -213,7 +214,8 @@ func TestHandler(t *testing.T) {
 			ctx = NewUserContext(ctx, user)
 
 			Convey("Then the user information should be in the context", func() {
-				retrieved := UserFromContext(ctx)
+				retrieved, ok := UserFromContext(ctx)
+				So(ok, ShouldBeTrue)
 				So(retrieved, ShouldResemble, user)
 			})
 		})
@@ -224,10 +226,62 @@ func TestHandler(t *testing.T) {
 
 		Convey("When no user information in the context", func() {
 			Convey("Then no user information should be in the context", func() {
-				user := UserFromContext(ctx)
+				user, ok := UserFromContext(ctx)
+				So(ok, ShouldBeFalse)
+				So(user, ShouldBeNil)
+			})
+		})
+
+		Convey("When another package uses the same key as a string", func() {
+			ctx = context.WithValue(ctx, "uid", NewMockUser("someid"))
+
+			Convey("Then no user information should be in the context", func() {
+				user, ok := UserFromContext(ctx)
+				So(ok, ShouldBeFalse)
 				So(user, ShouldBeNil)
 			})
 		})
+
+		Convey("When a nil user is stored", func() {
+			ctx = NewUserContext(ctx, nil)
+
+			Convey("Then no user information should be in the context", func() {
+				_, ok := UserFromContext(ctx)
+				So(ok, ShouldBeFalse)
+			})
+		})
+	})
+
+	Convey("Given a handler and a request with a context", t, func() {
+		storage := &mockContextStorage{
+			mockStorage: mockStorage{UID: "test_uid", Username: "test_user", Password: "test_pass"},
+		}
+		auth := NewAuthenticator(NewMockTokenGenerator(), nil, time.Hour, time.Hour,
+			WithContextStorage(storage),
+		)
+		handler := NewHandler(auth)
+
+		type key struct{}
+		ctx := context.WithValue(context.Background(), key{}, "value")
+		body := `{"username": "test_user", "password": "test_pass"}`
+		r := httptest.NewRequest("POST", "/", strings.NewReader(body)).WithContext(ctx)
+		r.Header.Set("Content-Type", "application/json")
+
+		Convey("When it is served with ServeHTTP", func() {
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+
+			Convey("Then the storage should get the request context", func() {
+				So(storage.ctx.Value(key{}), ShouldEqual, "value")
+			})
+		})
+
+		Convey("When it is served with CtxServeHTTP and a nil context", func() {
+			handler.CtxServeHTTP(nil, httptest.NewRecorder(), r)
+
+			Convey("Then the storage should get the request context", func() {
+				So(storage.ctx.Value(key{}), ShouldEqual, "value")
+			})
+		})
 	})
 }
 
@@ -261,6 +315,202 @@ func BenchmarkHandler(b *testing.B) {
 	server.Close()
 }
 
+func TestHandlerStatus(t *testing.T) {
+	if !testing.Verbose() {
+		t.Parallel()
+	}
+
+	Convey("Given a handler", t, func() {
+		handler := NewMockHandler()
+
+		serve := func(handler http.Handler, method string, target string, body string) (*httptest.ResponseRecorder, map[string]string) {
+			r := httptest.NewRequest(method, target, strings.NewReader(body))
+			if body != "" {
+				r.Header.Set("Content-Type", "application/json")
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			decoded := make(map[string]string)
+			So(json.NewDecoder(w.Body).Decode(&decoded), ShouldBeNil)
+			return w, decoded
+		}
+
+		Convey("When logging in", func() {
+			w, body := serve(handler, "POST", "/", `{"username": "test_user", "password": "test_pass"}`)
+
+			Convey("Then the response should have the JSON and cache headers", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(w.Header().Get("Content-Type"), ShouldEqual, "application/json")
+				So(w.Header().Get("Cache-Control"), ShouldEqual, "no-store")
+				So(w.Header().Get("Pragma"), ShouldEqual, "no-cache")
+				So(body["token"], ShouldNotBeEmpty)
+			})
+		})
+
+		Convey("When logging in with a GET request", func() {
+			w, body := serve(handler, "GET", "/?username=test_user&password=test_pass", "")
+
+			Convey("Then the method should not be allowed", func() {
+				So(w.Code, ShouldEqual, http.StatusMethodNotAllowed)
+				So(w.Header().Get("Allow"), ShouldEqual, "POST")
+				So(body["code"], ShouldEqual, CodeRequestInvalid)
+				So(body, ShouldNotContainKey, "token")
+			})
+		})
+
+		Convey("When logging in with credentials in the query", func() {
+			w, body := serve(handler, "POST", "/?username=test_user&password=test_pass", "")
+
+			Convey("Then the request should be invalid", func() {
+				So(w.Code, ShouldEqual, http.StatusBadRequest)
+				So(body["error"], ShouldEqual, ErrCredentialsInQuery.Error())
+			})
+		})
+
+		Convey("When logging in with malformed JSON", func() {
+			w, body := serve(handler, "POST", "/", `{`)
+
+			Convey("Then the request should be invalid", func() {
+				So(w.Code, ShouldEqual, http.StatusBadRequest)
+				So(body["code"], ShouldEqual, CodeRequestInvalid)
+			})
+		})
+
+		Convey("When logging in without a password", func() {
+			w, body := serve(handler, "POST", "/", `{"username": "test_user"}`)
+
+			Convey("Then the request should be invalid", func() {
+				So(w.Code, ShouldEqual, http.StatusBadRequest)
+				So(body["error"], ShouldEqual, ErrCredentialsMissing.Error())
+			})
+		})
+
+		Convey("When logging in with bad credentials", func() {
+			w, body := serve(handler, "POST", "/", `{"username": "test_user", "password": "invalid"}`)
+
+			Convey("Then the request should be unauthorized", func() {
+				So(w.Code, ShouldEqual, http.StatusUnauthorized)
+				So(body["error"], ShouldNotBeEmpty)
+				So(body, ShouldNotContainKey, "token")
+			})
+		})
+
+		Convey("When refreshing with an invalid refresh token", func() {
+			w, body := serve(handler, "POST", "/", `{"refresh_token": "invalid"}`)
+
+			Convey("Then the request should be unauthorized", func() {
+				So(w.Code, ShouldEqual, http.StatusUnauthorized)
+				So(body["code"], ShouldEqual, CodeTokenMalformed)
+			})
+		})
+
+		Convey("When the handler can not sign tokens", func() {
+			verifying := NewHandler(NewVerifyingAuthenticator(handler.auth.generator))
+			w, _ := serve(verifying, "POST", "/", `{"username": "test_user", "password": "test_pass"}`)
+
+			Convey("Then the response should be a server error", func() {
+				So(w.Code, ShouldEqual, http.StatusInternalServerError)
+			})
+		})
+
+		Convey("When the handler has legacy responses", func() {
+			legacy := NewHandler(handler.auth, WithLegacyResponses())
+
+			failed, failedBody := serve(legacy, "POST", "/", `{"username": "test_user", "password": "invalid"}`)
+			get, getBody := serve(legacy, "GET", "/?username=test_user&password=test_pass", "")
+
+			Convey("Then every response should have a 200 status", func() {
+				So(failed.Code, ShouldEqual, http.StatusOK)
+				So(failedBody["error"], ShouldNotBeEmpty)
+				So(get.Code, ShouldEqual, http.StatusOK)
+				So(getBody["token"], ShouldNotBeEmpty)
+			})
+		})
+	})
+}
+
+func TestHandlerRevoke(t *testing.T) {
+	if !testing.Verbose() {
+		t.Parallel()
+	}
+
+	Convey("Given a revocation endpoint", t, func() {
+		storage := NewMockStorage("test_uid", "test_user", "test_pass", nil)
+		auth := NewAuthenticator(NewMockTokenGenerator(), storage, time.Hour, time.Hour,
+			WithRevocationStore(NewMemoryRevocationStore(time.Hour)),
+		)
+		server := httptest.NewServer(NewHandler(auth).RevokeHandler())
+
+		Reset(func() {
+			server.Close()
+		})
+
+		token, _, err := auth.Authenticate("test_user", "test_pass")
+		So(err, ShouldBeNil)
+
+		Convey("When a token is revoked", func() {
+			response, err := http.PostForm(server.URL, url.Values{
+				"token":           []string{token},
+				"token_type_hint": []string{"access_token"},
+			})
+			So(err, ShouldBeNil)
+			response.Body.Close()
+
+			Convey("Then the response should be successful", func() {
+				So(response.StatusCode, ShouldEqual, http.StatusOK)
+			})
+
+			Convey("Then the token should not be valid", func() {
+				_, err := auth.ValidateToken(token)
+				So(err, ShouldEqual, ErrTokenRevoked)
+			})
+		})
+
+		Convey("When an invalid token is revoked", func() {
+			response, err := http.PostForm(server.URL, url.Values{
+				"token": []string{"invalid"},
+			})
+			So(err, ShouldBeNil)
+			response.Body.Close()
+
+			Convey("Then the response should be successful", func() {
+				So(response.StatusCode, ShouldEqual, http.StatusOK)
+			})
+		})
+
+		Convey("When no token is sent", func() {
+			response, err := http.PostForm(server.URL, url.Values{})
+			So(err, ShouldBeNil)
+
+			Convey("Then an invalid_request error should be returned", func() {
+				body := make(map[string]string)
+				decoder := json.NewDecoder(response.Body)
+				So(decoder.Decode(&body), ShouldBeNil)
+
+				So(response.StatusCode, ShouldEqual, http.StatusBadRequest)
+				So(body["error"], ShouldEqual, "invalid_request")
+			})
+		})
+
+		Convey("When the request is not a POST", func() {
+			response, err := http.Get(server.URL + "?token=" + token)
+			So(err, ShouldBeNil)
+			response.Body.Close()
+
+			Convey("Then the method should not be allowed", func() {
+				So(response.StatusCode, ShouldEqual, http.StatusMethodNotAllowed)
+			})
+
+			Convey("Then the token should still be valid", func() {
+				_, err := auth.ValidateToken(token)
+				So(err, ShouldBeNil)
+			})
+		})
+	})
+}
+
 func NewMockHandler() *Handler {
 	method := NewMockSigningMethod()
 	storage := NewMockStorage("test_uid", "test_user", "test_pass", []string{"permission1", "permission2"})
@@ -0,0 +1,325 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+
+	"github.com/pquerna/ffjson/ffjson"
+
+	"gopkg.in/dgrijalva/jwt-go.v2"
+)
+
+// Errors returned when publishing keys
+var (
+	ErrKeysNotPublishable = errors.New("Signing method keys can not be published")
+	ErrKeyTypeUnsupported = errors.New("Key type is not supported")
+	ErrKeyInvalid         = errors.New("Key is invalid")
+	ErrKeyNotPrivate      = errors.New("Key is not a private key")
+)
+
+var curves = map[string]elliptic.Curve{
+	"P-256": elliptic.P256(),
+	"P-384": elliptic.P384(),
+	"P-521": elliptic.P521(),
+}
+
+// JWK is a JSON Web Key as described in RFC 7517, private key parameters are
+// only used when loading keys and are never published
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	KeyID     string `json:"kid,omitempty"`
+
+	// RSA public key parameters
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// Elliptic curve and octet key pair public key parameters
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	Y     string `json:"y,omitempty"`
+
+	// Private key parameters
+	D  string `json:"d,omitempty"`
+	P  string `json:"p,omitempty"`
+	Q  string `json:"q,omitempty"`
+	DP string `json:"dp,omitempty"`
+	DQ string `json:"dq,omitempty"`
+	QI string `json:"qi,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set as described in RFC 7517
+type JWKS struct {
+	Keys []*JWK `json:"keys"`
+}
+
+// NewJWK creates a signature JWK for a public key
+func NewJWK(kid interface{}, alg string, key interface{}) (*JWK, error) {
+	jwk := &JWK{
+		Use:       "sig",
+		Algorithm: alg,
+	}
+
+	if kid != nil {
+		jwk.KeyID = fmt.Sprint(kid)
+	}
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = encodeBigInt(k.N, 0)
+		jwk.E = encodeBigInt(big.NewInt(int64(k.E)), 0)
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		jwk.KeyType = "EC"
+		jwk.Curve = k.Curve.Params().Name
+		jwk.X = encodeBigInt(k.X, size)
+		jwk.Y = encodeBigInt(k.Y, size)
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = jwt.EncodeSegment(k)
+	default:
+		return nil, ErrKeyTypeUnsupported
+	}
+
+	return jwk, nil
+}
+
+// PublicKey returns the public key described by the JWK
+func (j *JWK) PublicKey() (interface{}, error) {
+	switch j.KeyType {
+	case "RSA":
+		n, err := decodeBigInt(j.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(j.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{
+			N: n,
+			E: int(e.Int64()),
+		}, nil
+	case "EC":
+		curve, ok := curves[j.Curve]
+		if !ok {
+			return nil, ErrKeyTypeUnsupported
+		}
+		x, err := decodeBigInt(j.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(j.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, ErrKeyInvalid
+		}
+		return &ecdsa.PublicKey{
+			Curve: curve,
+			X:     x,
+			Y:     y,
+		}, nil
+	case "OKP":
+		if j.Curve != "Ed25519" {
+			return nil, ErrKeyTypeUnsupported
+		}
+		x, err := jwt.DecodeSegment(j.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, ErrKeyInvalid
+		}
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, ErrKeyTypeUnsupported
+}
+
+// PrivateKey returns the private key described by the JWK
+func (j *JWK) PrivateKey() (crypto.PrivateKey, error) {
+	public, err := j.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	if j.D == "" {
+		return nil, ErrKeyNotPrivate
+	}
+
+	switch key := public.(type) {
+	case *rsa.PublicKey:
+		d, err := decodeBigInt(j.D)
+		if err != nil {
+			return nil, err
+		}
+		p, err := decodeBigInt(j.P)
+		if err != nil {
+			return nil, err
+		}
+		q, err := decodeBigInt(j.Q)
+		if err != nil {
+			return nil, err
+		}
+		private := &rsa.PrivateKey{
+			PublicKey: *key,
+			D:         d,
+			Primes:    []*big.Int{p, q},
+		}
+		if err := private.Validate(); err != nil {
+			return nil, err
+		}
+		private.Precompute()
+		return private, nil
+	case *ecdsa.PublicKey:
+		d, err := decodeBigInt(j.D)
+		if err != nil {
+			return nil, err
+		}
+		x, y := key.Curve.ScalarBaseMult(d.Bytes())
+		if x.Cmp(key.X) != 0 || y.Cmp(key.Y) != 0 {
+			return nil, ErrKeyInvalid
+		}
+		return &ecdsa.PrivateKey{
+			PublicKey: *key,
+			D:         d,
+		}, nil
+	case ed25519.PublicKey:
+		seed, err := jwt.DecodeSegment(j.D)
+		if err != nil {
+			return nil, err
+		}
+		if len(seed) != ed25519.SeedSize {
+			return nil, ErrKeyInvalid
+		}
+		private := ed25519.NewKeyFromSeed(seed)
+		if !key.Equal(private.Public()) {
+			return nil, ErrKeyInvalid
+		}
+		return private, nil
+	}
+
+	return nil, ErrKeyTypeUnsupported
+}
+
+// Algorithms returns the signing algorithms the key may be used with, if the
+// key does not specify an algorithm every algorithm matching its type is
+// returned
+func (j *JWK) Algorithms() []string {
+	if j.Algorithm != "" {
+		return []string{j.Algorithm}
+	}
+
+	switch j.KeyType {
+	case "RSA":
+		return []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512"}
+	case "EC":
+		switch j.Curve {
+		case "P-256":
+			return []string{"ES256"}
+		case "P-384":
+			return []string{"ES384"}
+		case "P-521":
+			return []string{"ES512"}
+		}
+	case "OKP":
+		if j.Curve == "Ed25519" {
+			return []string{"EdDSA"}
+		}
+	}
+
+	return nil
+}
+
+// NewJWKS creates a JWKS containing the public keys of a signing method
+func NewJWKS(method SigningMethod) (*JWKS, error) {
+	set, ok := method.(KeySet)
+	if !ok {
+		return nil, ErrKeysNotPublishable
+	}
+
+	kids := set.KIDs()
+	jwks := &JWKS{
+		Keys: make([]*JWK, 0, len(kids)),
+	}
+
+	for _, kid := range kids {
+		// Keys can be retired between listing and lookup
+		key := method.PublicKey(kid)
+		if key == nil {
+			continue
+		}
+
+		jwk, err := NewJWK(kid, method.Method().Alg(), key)
+		if err != nil {
+			return nil, err
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+
+	return jwks, nil
+}
+
+// JWKSHandler publishes the public keys of a signing method
+type JWKSHandler struct {
+	method SigningMethod
+}
+
+// NewJWKSHandler creates a JWKSHandler, signing methods using shared secrets
+// can not be published
+func NewJWKSHandler(method SigningMethod) (*JWKSHandler, error) {
+	if _, ok := method.(KeySet); !ok {
+		return nil, ErrKeysNotPublishable
+	}
+
+	return &JWKSHandler{
+		method: method,
+	}, nil
+}
+
+// ServeHTTP implements http.Handler
+func (h *JWKSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	jwks, err := NewJWKS(h.method)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	encoder := ffjson.NewEncoder(w)
+	encoder.Encode(jwks)
+}
+
+func encodeBigInt(i *big.Int, size int) string {
+	buf := i.Bytes()
+	if len(buf) < size {
+		padded := make([]byte, size)
+		copy(padded[size-len(buf):], buf)
+		buf = padded
+	}
+	return jwt.EncodeSegment(buf)
+}
+
+func decodeBigInt(str string) (*big.Int, error) {
+	if str == "" {
+		return nil, ErrKeyInvalid
+	}
+
+	buf, err := jwt.DecodeSegment(str)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(buf), nil
+}
@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gopkg.in/dgrijalva/jwt-go.v2"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestJWKS(t *testing.T) {
+	if !testing.Verbose() {
+		t.Parallel()
+	}
+
+	Convey("Given a RSA signing method", t, func() {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		So(err, ShouldBeNil)
+
+		method, err := SigningMethodRSA(SimpleRSAKeyGen(key), Size256)
+		So(err, ShouldBeNil)
+
+		Convey("When the JWKS is created", func() {
+			jwks, err := NewJWKS(method)
+			So(err, ShouldBeNil)
+
+			Convey("Then it should contain the public key", func() {
+				So(jwks.Keys, ShouldHaveLength, 1)
+				So(jwks.Keys[0].KeyType, ShouldEqual, "RSA")
+				So(jwks.Keys[0].Algorithm, ShouldEqual, "RS256")
+				So(jwks.Keys[0].Use, ShouldEqual, "sig")
+
+				n, err := jwt.DecodeSegment(jwks.Keys[0].N)
+				So(err, ShouldBeNil)
+				So(new(big.Int).SetBytes(n).Cmp(key.N), ShouldEqual, 0)
+
+				e, err := jwt.DecodeSegment(jwks.Keys[0].E)
+				So(err, ShouldBeNil)
+				So(new(big.Int).SetBytes(e).Int64(), ShouldEqual, key.E)
+			})
+		})
+	})
+
+	Convey("Given a RSAPSS signing method", t, func() {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		So(err, ShouldBeNil)
+
+		method, err := SigningMethodRSAPSS(SimpleRSAKeyGen(key), Size384)
+		So(err, ShouldBeNil)
+
+		Convey("When the JWKS is created", func() {
+			jwks, err := NewJWKS(method)
+			So(err, ShouldBeNil)
+
+			Convey("Then the key should have the PSS algorithm", func() {
+				So(jwks.Keys, ShouldHaveLength, 1)
+				So(jwks.Keys[0].KeyType, ShouldEqual, "RSA")
+				So(jwks.Keys[0].Algorithm, ShouldEqual, "PS384")
+			})
+		})
+	})
+
+	Convey("Given a ECDSA signing method", t, func() {
+		key, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+		So(err, ShouldBeNil)
+
+		method, err := SigningMethodECDSA(SimpleECDSAKeyGen(key), Size512)
+		So(err, ShouldBeNil)
+
+		Convey("When the JWKS is created", func() {
+			jwks, err := NewJWKS(method)
+			So(err, ShouldBeNil)
+
+			Convey("Then it should contain the padded curve point", func() {
+				So(jwks.Keys, ShouldHaveLength, 1)
+				So(jwks.Keys[0].KeyType, ShouldEqual, "EC")
+				So(jwks.Keys[0].Algorithm, ShouldEqual, "ES512")
+				So(jwks.Keys[0].Curve, ShouldEqual, "P-521")
+
+				x, err := jwt.DecodeSegment(jwks.Keys[0].X)
+				So(err, ShouldBeNil)
+				So(x, ShouldHaveLength, 66)
+				So(new(big.Int).SetBytes(x).Cmp(key.X), ShouldEqual, 0)
+
+				y, err := jwt.DecodeSegment(jwks.Keys[0].Y)
+				So(err, ShouldBeNil)
+				So(y, ShouldHaveLength, 66)
+				So(new(big.Int).SetBytes(y).Cmp(key.Y), ShouldEqual, 0)
+			})
+		})
+	})
+
+	Convey("Given a HMAC signing method", t, func() {
+		method := NewMockSigningMethod()
+
+		Convey("When a JWKS handler is created", func() {
+			handler, err := NewJWKSHandler(method)
+
+			Convey("Then an error should be returned", func() {
+				So(handler, ShouldBeNil)
+				So(err, ShouldEqual, ErrKeysNotPublishable)
+			})
+		})
+	})
+
+	Convey("Given a JWKS handler", t, func() {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		So(err, ShouldBeNil)
+
+		method, err := SigningMethodECDSA(SimpleECDSAKeyGen(key), Size256)
+		So(err, ShouldBeNil)
+
+		handler, err := NewJWKSHandler(method)
+		So(err, ShouldBeNil)
+
+		server := httptest.NewServer(handler)
+
+		Reset(func() {
+			server.Close()
+		})
+
+		Convey("When the key set is requested", func() {
+			response, err := http.Get(server.URL)
+			So(err, ShouldBeNil)
+
+			Convey("Then the key set should be returned", func() {
+				So(response.Header.Get("Content-Type"), ShouldEqual, "application/json")
+
+				jwks := &JWKS{}
+				err := json.NewDecoder(response.Body).Decode(jwks)
+				So(err, ShouldBeNil)
+				So(jwks.Keys, ShouldHaveLength, 1)
+				So(jwks.Keys[0].Curve, ShouldEqual, "P-256")
+			})
+		})
+	})
+}
@@ -0,0 +1,559 @@
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"hash"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pquerna/ffjson/ffjson"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Errors returned when loading keys
+var (
+	ErrKeyFormatUnknown      = errors.New("Key format is not recognised")
+	ErrKeyEncrypted          = errors.New("Key is encrypted and no passphrase was given")
+	ErrKeyEncryptionUnknown  = errors.New("Key encryption is not supported")
+	ErrKeyTypesMixed         = errors.New("Keys must all be the same type")
+	ErrKeyAlgorithmsMixed    = errors.New("Keys must all use the same algorithm")
+	ErrNoKeys                = errors.New("No keys found")
+	ErrAlgorithmNotSupported = errors.New("Algorithm is not supported")
+	ErrKeyIDMissing          = errors.New("Keys in a key set must have a kid")
+)
+
+type loadedKey struct {
+	kid string
+	alg string
+	key crypto.PrivateKey
+}
+
+// ParsePrivateKey parses a PEM (PKCS#1, PKCS#8 or SEC1), DER or JWK encoded
+// private key, the passphrase is only used for encrypted PEM blocks and
+// encrypted PKCS#8 keys using PBES2
+func ParsePrivateKey(data []byte, passphrase []byte) (crypto.PrivateKey, error) {
+	keys, err := parseKeys(data, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return keys[0].key, nil
+}
+
+// LoadKeyFile loads a signing method from a PEM, DER, JWK or JWKS file. The
+// kid is taken from the JWK or the file name without its extension. If size
+// is zero it is taken from the JWK algorithm or the key, RSA keys default
+// to Size256.
+func LoadKeyFile(path string, size Size, passphrase []byte) (SigningMethod, error) {
+	keys, err := loadKeyFile(path, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return signingMethodForKeys(keys, size)
+}
+
+// LoadKeyDir loads a signing method from a directory of key files, the kid is
+// taken from the JWK or the file name without its extension, like
+// LoadKeyFile. The most recently modified file is used for signing while the
+// rest can only verify.
+func LoadKeyDir(dir string, size Size, passphrase []byte) (SigningMethod, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Newest first, the first key loaded becomes the active key
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ModTime().After(infos[j].ModTime())
+	})
+
+	var keys []*loadedKey
+	for _, info := range infos {
+		if !info.Mode().IsRegular() || strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+
+		loaded, err := loadKeyFile(filepath.Join(dir, info.Name()), passphrase)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, loaded...)
+	}
+
+	if len(keys) == 0 {
+		return nil, ErrNoKeys
+	}
+
+	return signingMethodForKeys(keys, size)
+}
+
+// SigningMethodForKey creates the signing method matching the key type, if
+// size is zero it is taken from the key, RSA keys default to Size256
+func SigningMethodForKey(key crypto.PrivateKey, size Size) (SigningMethod, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if size == 0 {
+			size = Size256
+		}
+		if !validSize(size) {
+			return nil, ErrSizeInvalid
+		}
+		return SigningMethodRSA(SimpleRSAKeyGen(k), size)
+	case *ecdsa.PrivateKey:
+		size, err := curveSize(k, size)
+		if err != nil {
+			return nil, err
+		}
+		return SigningMethodECDSA(SimpleECDSAKeyGen(k), size)
+	case ed25519.PrivateKey:
+		return SigningMethodEd25519(SimpleEd25519KeyGen(k))
+	}
+
+	return nil, ErrKeyTypeUnsupported
+}
+
+func loadKeyFile(path string, passphrase []byte) ([]*loadedKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := parseKeys(data, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	// The file name can only name one key
+	for _, key := range keys {
+		if key.kid == "" && len(keys) > 1 {
+			return nil, ErrKeyIDMissing
+		}
+		if key.kid == "" {
+			key.kid = kidFromPath(path)
+		}
+	}
+
+	return keys, nil
+}
+
+func parseKeys(data []byte, passphrase []byte) ([]*loadedKey, error) {
+	trimmed := bytes.TrimSpace(data)
+
+	if bytes.HasPrefix(trimmed, []byte("{")) {
+		return parseJWKKeys(trimmed)
+	}
+
+	var key crypto.PrivateKey
+	var err error
+
+	if bytes.HasPrefix(trimmed, []byte("-----BEGIN")) {
+		key, err = parsePEMKey(trimmed, passphrase)
+	} else {
+		key, err = parseDERKey(data)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return []*loadedKey{{key: key}}, nil
+}
+
+func parseJWKKeys(data []byte) ([]*loadedKey, error) {
+	var jwks struct {
+		JWK
+		Keys []*JWK `json:"keys"`
+	}
+
+	if err := ffjson.Unmarshal(data, &jwks); err != nil {
+		return nil, err
+	}
+
+	set := jwks.Keys
+	if set == nil {
+		set = []*JWK{&jwks.JWK}
+	}
+
+	keys := make([]*loadedKey, 0, len(set))
+	for _, jwk := range set {
+		key, err := jwk.PrivateKey()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, &loadedKey{
+			kid: jwk.KeyID,
+			alg: jwk.Algorithm,
+			key: key,
+		})
+	}
+
+	if len(keys) == 0 {
+		return nil, ErrNoKeys
+	}
+
+	return keys, nil
+}
+
+func parsePEMKey(data []byte, passphrase []byte) (crypto.PrivateKey, error) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, ErrNoKeys
+		}
+
+		// Certificates and parameters may be bundled alongside the key
+		if !strings.HasSuffix(block.Type, "PRIVATE KEY") {
+			continue
+		}
+
+		der := block.Bytes
+
+		if block.Type == "ENCRYPTED PRIVATE KEY" {
+			if passphrase == nil {
+				return nil, ErrKeyEncrypted
+			}
+
+			var err error
+			der, err = decryptPKCS8(der, passphrase)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if x509.IsEncryptedPEMBlock(block) {
+			if passphrase == nil {
+				return nil, ErrKeyEncrypted
+			}
+
+			var err error
+			der, err = x509.DecryptPEMBlock(block, passphrase)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return parseDERKey(der)
+	}
+}
+
+func parseDERKey(der []byte) (crypto.PrivateKey, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		return key, nil
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+
+	return nil, ErrKeyFormatUnknown
+}
+
+// OIDs of the PBES2 algorithms, RFC 8018
+var (
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}
+	oidHMACWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+	oidAES128CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Data      []byte
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// decryptPKCS8 decrypts an encrypted PKCS#8 key, only PBES2 with PBKDF2 and
+// AES-CBC is supported as it is what OpenSSL uses by default
+func decryptPKCS8(der []byte, passphrase []byte) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, err
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, ErrKeyEncryptionUnknown
+	}
+
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, err
+	}
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, ErrKeyEncryptionUnknown
+	}
+
+	var kdf pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+		return nil, err
+	}
+
+	var prf func() hash.Hash
+	switch {
+	case len(kdf.PRF.Algorithm) == 0, kdf.PRF.Algorithm.Equal(oidHMACWithSHA1):
+		prf = sha1.New
+	case kdf.PRF.Algorithm.Equal(oidHMACWithSHA256):
+		prf = sha256.New
+	case kdf.PRF.Algorithm.Equal(oidHMACWithSHA384):
+		prf = sha512.New384
+	case kdf.PRF.Algorithm.Equal(oidHMACWithSHA512):
+		prf = sha512.New
+	default:
+		return nil, ErrKeyEncryptionUnknown
+	}
+
+	var keyLength int
+	switch scheme := params.EncryptionScheme.Algorithm; {
+	case scheme.Equal(oidAES128CBC):
+		keyLength = 16
+	case scheme.Equal(oidAES192CBC):
+		keyLength = 24
+	case scheme.Equal(oidAES256CBC):
+		keyLength = 32
+	default:
+		return nil, ErrKeyEncryptionUnknown
+	}
+
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, err
+	}
+
+	if kdf.IterationCount < 1 || (kdf.KeyLength != 0 && kdf.KeyLength != keyLength) ||
+		len(iv) != aes.BlockSize || len(info.Data) == 0 || len(info.Data)%aes.BlockSize != 0 {
+		return nil, ErrKeyFormatUnknown
+	}
+
+	key := pbkdf2.Key(passphrase, kdf.Salt, kdf.IterationCount, keyLength, prf)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, len(info.Data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(data, info.Data)
+
+	// A wrong passphrase almost always leaves invalid padding
+	padding := int(data[len(data)-1])
+	if padding < 1 || padding > aes.BlockSize {
+		return nil, x509.IncorrectPasswordError
+	}
+	for _, b := range data[len(data)-padding:] {
+		if int(b) != padding {
+			return nil, x509.IncorrectPasswordError
+		}
+	}
+
+	return data[:len(data)-padding], nil
+}
+
+func signingMethodForKeys(keys []*loadedKey, size Size) (SigningMethod, error) {
+	pss := false
+	alg := ""
+
+	// A size or algorithm given explicitly must agree with the JWK algorithm,
+	// one signing method can only have one algorithm
+	for _, key := range keys {
+		if key.alg == "" {
+			continue
+		}
+		if alg != "" && key.alg != alg {
+			return nil, ErrKeyAlgorithmsMixed
+		}
+		alg = key.alg
+
+		algSize, algPSS, err := parseAlgorithm(key.alg)
+		if err != nil {
+			return nil, err
+		}
+		if !algorithmMatchesKey(key.alg, key.key) {
+			return nil, ErrKeyAlgorithmMismatch
+		}
+		if size != 0 && algSize != 0 && size != algSize {
+			return nil, ErrSizeInvalid
+		}
+		if algSize != 0 {
+			size = algSize
+		}
+		pss = algPSS
+	}
+
+	switch keys[0].key.(type) {
+	case *rsa.PrivateKey:
+		if size == 0 {
+			size = Size256
+		}
+		if !validSize(size) {
+			return nil, ErrSizeInvalid
+		}
+
+		keyring, err := NewRSAKeyring(keys[0].key.(*rsa.PrivateKey).N.BitLen(), KeyringOptions{})
+		if err != nil {
+			return nil, err
+		}
+		if err := addLoadedKeys(keyring.Keyring, keys, func(key crypto.PrivateKey) bool {
+			_, ok := key.(*rsa.PrivateKey)
+			return ok
+		}); err != nil {
+			return nil, err
+		}
+
+		if pss {
+			return SigningMethodRSAPSS(keyring, size)
+		}
+		return SigningMethodRSA(keyring, size)
+	case *ecdsa.PrivateKey:
+		first := keys[0].key.(*ecdsa.PrivateKey)
+		size, err := curveSize(first, size)
+		if err != nil {
+			return nil, err
+		}
+
+		keyring := NewECDSAKeyring(first.Curve, KeyringOptions{})
+		if err := addLoadedKeys(keyring.Keyring, keys, func(key crypto.PrivateKey) bool {
+			k, ok := key.(*ecdsa.PrivateKey)
+			return ok && k.Curve == first.Curve
+		}); err != nil {
+			return nil, err
+		}
+
+		return SigningMethodECDSA(keyring, size)
+	case ed25519.PrivateKey:
+		keyring := NewEd25519Keyring(KeyringOptions{})
+		if err := addLoadedKeys(keyring.Keyring, keys, func(key crypto.PrivateKey) bool {
+			_, ok := key.(ed25519.PrivateKey)
+			return ok
+		}); err != nil {
+			return nil, err
+		}
+
+		return SigningMethodEd25519(keyring)
+	}
+
+	return nil, ErrKeyTypeUnsupported
+}
+
+// addLoadedKeys adds the keys to the keyring, the first key is active
+func addLoadedKeys(keyring *Keyring, keys []*loadedKey, match func(crypto.PrivateKey) bool) error {
+	for i, key := range keys {
+		if !match(key.key) {
+			return ErrKeyTypesMixed
+		}
+
+		state := KeyVerifyOnly
+		if i == 0 {
+			state = KeyActive
+		}
+
+		if err := keyring.Add(key.kid, key.key, state); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseAlgorithm(alg string) (Size, bool, error) {
+	if alg == "EdDSA" {
+		return 0, false, nil
+	}
+
+	if len(alg) != 5 {
+		return 0, false, ErrAlgorithmNotSupported
+	}
+
+	var size Size
+	switch alg[2:] {
+	case "256":
+		size = Size256
+	case "384":
+		size = Size384
+	case "512":
+		size = Size512
+	default:
+		return 0, false, ErrAlgorithmNotSupported
+	}
+
+	switch alg[:2] {
+	case "RS", "ES":
+		return size, false, nil
+	case "PS":
+		return size, true, nil
+	}
+
+	return 0, false, ErrAlgorithmNotSupported
+}
+
+func algorithmMatchesKey(alg string, key crypto.PrivateKey) bool {
+	switch key.(type) {
+	case *rsa.PrivateKey:
+		return strings.HasPrefix(alg, "RS") || strings.HasPrefix(alg, "PS")
+	case *ecdsa.PrivateKey:
+		return strings.HasPrefix(alg, "ES")
+	case ed25519.PrivateKey:
+		return alg == "EdDSA"
+	}
+	return false
+}
+
+func curveSize(key *ecdsa.PrivateKey, size Size) (Size, error) {
+	var curve Size
+	switch key.Curve.Params().BitSize {
+	case 256:
+		curve = Size256
+	case 384:
+		curve = Size384
+	case 521:
+		curve = Size512
+	default:
+		return 0, ErrKeyTypeUnsupported
+	}
+
+	if size != 0 && size != curve {
+		return 0, ErrSizeInvalid
+	}
+	return curve, nil
+}
+
+func validSize(size Size) bool {
+	return size == Size256 || size == Size384 || size == Size512
+}
+
+func kidFromPath(path string) string {
+	name := filepath.Base(path)
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
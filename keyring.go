@@ -0,0 +1,510 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"sync"
+	"time"
+
+	"gopkg.in/dgrijalva/jwt-go.v2"
+)
+
+// Errors returned from Keyring
+var (
+	ErrKeyExists      = errors.New("Key already exists")
+	ErrKeyNotFound    = errors.New("Key not found")
+	ErrKeyTypeInvalid = errors.New("Key type is not valid for the keyring")
+)
+
+// KeyState is the state of a key in a Keyring
+type KeyState int
+
+// Key states, keys move through these states in order
+const (
+	// KeyPending keys are published but not yet used for signing
+	KeyPending KeyState = iota
+	// KeyActive is the single key used for signing
+	KeyActive
+	// KeyVerifyOnly keys are no longer used for signing but still verify
+	// tokens signed before rotation
+	KeyVerifyOnly
+	// KeyRetired keys are removed from the keyring
+	KeyRetired
+)
+
+func (s KeyState) String() string {
+	switch s {
+	case KeyPending:
+		return "pending"
+	case KeyActive:
+		return "active"
+	case KeyVerifyOnly:
+		return "verify-only"
+	case KeyRetired:
+		return "retired"
+	}
+	return "unknown"
+}
+
+// KeyringOptions configures a Keyring
+type KeyringOptions struct {
+	// RotationInterval is how often keys are rotated once Start is called
+	RotationInterval time.Duration
+
+	// VerifyPeriod is how long a key can verify tokens after it stops being
+	// active, it should be at least the lifetime of the longest lived token
+	VerifyPeriod time.Duration
+
+	// OnStateChange is called whenever a key changes state so the change can
+	// be persisted, including keys added with Add. The time of the change
+	// should be persisted too and passed to AddAt when the key is restored.
+	OnStateChange func(kid string, key crypto.PrivateKey, state KeyState, changed time.Time)
+}
+
+type keyringEntry struct {
+	kid     string
+	key     crypto.PrivateKey
+	state   KeyState
+	changed time.Time
+}
+
+type keyChange struct {
+	kid     string
+	key     crypto.PrivateKey
+	state   KeyState
+	changed time.Time
+}
+
+// Keyring holds multiple keys, signing with the active key and verifying with
+// any key that has not been retired
+type Keyring struct {
+	generate func() (crypto.PrivateKey, error)
+	check    func(crypto.PrivateKey) error
+	options  KeyringOptions
+
+	mutex   sync.RWMutex
+	entries []*keyringEntry
+	stop    chan struct{}
+}
+
+// newKeyring creates a Keyring, check rejects keys the keyring can not hold
+func newKeyring(generate func() (crypto.PrivateKey, error), check func(crypto.PrivateKey) error, options KeyringOptions) *Keyring {
+	return &Keyring{
+		generate: generate,
+		check:    check,
+		options:  options,
+	}
+}
+
+// Add adds an existing key to the keyring that entered the state now.
+// ErrKeyTypeInvalid is returned if the keyring can not hold the key.
+func (k *Keyring) Add(kid string, key crypto.PrivateKey, state KeyState) error {
+	return k.AddAt(kid, key, state, time.Now())
+}
+
+// AddAt adds an existing key to the keyring that entered the state at
+// changed, for example one restored from persistent storage. Restored
+// verify-only keys keep their original verify period.
+func (k *Keyring) AddAt(kid string, key crypto.PrivateKey, state KeyState, changed time.Time) error {
+	if err := k.check(key); err != nil {
+		return err
+	}
+
+	k.mutex.Lock()
+
+	if k.entry(kid) != nil {
+		k.mutex.Unlock()
+		return ErrKeyExists
+	}
+
+	var changes []keyChange
+	if state == KeyActive {
+		changes = k.deactivate(time.Now())
+	}
+
+	if state != KeyRetired {
+		changes = append(changes, k.insert(&keyringEntry{kid: kid, key: key}, state, changed))
+	}
+
+	k.mutex.Unlock()
+	k.notify(changes)
+
+	return nil
+}
+
+// SetState changes the state of a key, activating a key moves the current
+// active key to verify-only
+func (k *Keyring) SetState(kid string, state KeyState) error {
+	k.mutex.Lock()
+
+	entry := k.entry(kid)
+	if entry == nil {
+		k.mutex.Unlock()
+		return ErrKeyNotFound
+	}
+
+	if entry.state == state {
+		k.mutex.Unlock()
+		return nil
+	}
+
+	now := time.Now()
+
+	var changes []keyChange
+	if state == KeyActive {
+		changes = k.deactivate(now)
+	}
+
+	changes = append(changes, k.transition(entry, state, now))
+
+	k.mutex.Unlock()
+	k.notify(changes)
+
+	return nil
+}
+
+// Rotate promotes the pending key to active, or generates a new active key if
+// there is none pending. The previously active key becomes verify-only, a new
+// pending key is generated and verify-only keys older than the verify period
+// are retired.
+func (k *Keyring) Rotate() error {
+	k.mutex.RLock()
+	count := 1
+	if k.pending() == nil {
+		count = 2
+	}
+	k.mutex.RUnlock()
+
+	// Keys are generated before locking as generation can be slow
+	fresh := make([]*keyringEntry, 0, count)
+	for i := 0; i < count; i++ {
+		entry, err := k.newEntry()
+		if err != nil {
+			return err
+		}
+		fresh = append(fresh, entry)
+	}
+
+	k.mutex.Lock()
+
+	now := time.Now()
+	changes := k.deactivate(now)
+
+	if pending := k.pending(); pending != nil {
+		changes = append(changes, k.transition(pending, KeyActive, now))
+	} else {
+		changes = append(changes, k.insert(fresh[0], KeyActive, now))
+		fresh = fresh[1:]
+	}
+
+	// A pending key is always kept so verifiers can learn it before use
+	if len(fresh) > 0 {
+		changes = append(changes, k.insert(fresh[0], KeyPending, now))
+	}
+
+	changes = append(changes, k.prune(now)...)
+
+	k.mutex.Unlock()
+	k.notify(changes)
+
+	return nil
+}
+
+// Prune retires verify-only keys older than the verify period
+func (k *Keyring) Prune() {
+	k.mutex.Lock()
+	changes := k.prune(time.Now())
+	k.mutex.Unlock()
+	k.notify(changes)
+}
+
+// Start rotates the keys every RotationInterval until Stop is called
+func (k *Keyring) Start() {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if k.stop != nil || k.options.RotationInterval <= 0 {
+		return
+	}
+
+	stop := make(chan struct{})
+	k.stop = stop
+
+	go func() {
+		ticker := time.NewTicker(k.options.RotationInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				k.Rotate()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops scheduled rotation
+func (k *Keyring) Stop() {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if k.stop != nil {
+		close(k.stop)
+		k.stop = nil
+	}
+}
+
+// State returns the state of a key, keys not in the keyring are retired
+func (k *Keyring) State(kid string) KeyState {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+
+	if entry := k.entry(kid); entry != nil {
+		return entry.state
+	}
+	return KeyRetired
+}
+
+// KID returns the id of the active key
+func (k *Keyring) KID() interface{} {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+
+	for _, entry := range k.entries {
+		if entry.state == KeyActive {
+			return entry.kid
+		}
+	}
+	return nil
+}
+
+// KIDs returns the ids of all keys that have not been retired
+func (k *Keyring) KIDs() []interface{} {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+
+	kids := make([]interface{}, 0, len(k.entries))
+	for _, entry := range k.entries {
+		kids = append(kids, entry.kid)
+	}
+	return kids
+}
+
+func (k *Keyring) key(kid interface{}) crypto.PrivateKey {
+	str, ok := kid.(string)
+	if !ok {
+		return nil
+	}
+
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+
+	if entry := k.entry(str); entry != nil {
+		return entry.key
+	}
+	return nil
+}
+
+func (k *Keyring) entry(kid string) *keyringEntry {
+	for _, entry := range k.entries {
+		if entry.kid == kid {
+			return entry
+		}
+	}
+	return nil
+}
+
+func (k *Keyring) pending() *keyringEntry {
+	for _, entry := range k.entries {
+		if entry.state == KeyPending {
+			return entry
+		}
+	}
+	return nil
+}
+
+func (k *Keyring) newEntry() (*keyringEntry, error) {
+	key, err := k.generate()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := k.check(key); err != nil {
+		return nil, err
+	}
+
+	kid := make([]byte, 12)
+	if _, err := rand.Read(kid); err != nil {
+		return nil, err
+	}
+
+	return &keyringEntry{
+		kid: jwt.EncodeSegment(kid),
+		key: key,
+	}, nil
+}
+
+func (k *Keyring) deactivate(now time.Time) []keyChange {
+	var changes []keyChange
+	for _, entry := range k.entries {
+		if entry.state == KeyActive {
+			changes = append(changes, k.transition(entry, KeyVerifyOnly, now))
+		}
+	}
+	return changes
+}
+
+func (k *Keyring) prune(now time.Time) []keyChange {
+	var expired []*keyringEntry
+	for _, entry := range k.entries {
+		if entry.state == KeyVerifyOnly && now.Sub(entry.changed) >= k.options.VerifyPeriod {
+			expired = append(expired, entry)
+		}
+	}
+
+	changes := make([]keyChange, 0, len(expired))
+	for _, entry := range expired {
+		changes = append(changes, k.transition(entry, KeyRetired, now))
+	}
+	return changes
+}
+
+func (k *Keyring) insert(entry *keyringEntry, state KeyState, now time.Time) keyChange {
+	entry.state = state
+	entry.changed = now
+	k.entries = append(k.entries, entry)
+	return keyChange{kid: entry.kid, key: entry.key, state: state, changed: now}
+}
+
+func (k *Keyring) transition(entry *keyringEntry, state KeyState, now time.Time) keyChange {
+	entry.state = state
+	entry.changed = now
+
+	if state == KeyRetired {
+		for i, e := range k.entries {
+			if e == entry {
+				k.entries = append(k.entries[:i], k.entries[i+1:]...)
+				break
+			}
+		}
+	}
+
+	return keyChange{kid: entry.kid, key: entry.key, state: state, changed: now}
+}
+
+func (k *Keyring) notify(changes []keyChange) {
+	if k.options.OnStateChange == nil {
+		return
+	}
+
+	for _, change := range changes {
+		k.options.OnStateChange(change.kid, change.key, change.state, change.changed)
+	}
+}
+
+// RSAKeyring is a Keyring of RSA keys implementing RSAKeyGen
+type RSAKeyring struct {
+	*Keyring
+}
+
+// NewRSAKeyring creates an empty RSAKeyring generating keys of the given bit
+// size, call Rotate or Add to add the first key. Keys must be at least
+// MinRSAKeySize bits.
+func NewRSAKeyring(bits int, options KeyringOptions) (*RSAKeyring, error) {
+	if bits < MinRSAKeySize {
+		return nil, ErrKeyTooSmall
+	}
+
+	return &RSAKeyring{
+		Keyring: newKeyring(func() (crypto.PrivateKey, error) {
+			return rsa.GenerateKey(rand.Reader, bits)
+		}, func(key crypto.PrivateKey) error {
+			rsaKey, ok := key.(*rsa.PrivateKey)
+			if !ok {
+				return ErrKeyTypeInvalid
+			}
+			if rsaKey.N.BitLen() < MinRSAKeySize {
+				return ErrKeyTooSmall
+			}
+			return nil
+		}, options),
+	}, nil
+}
+
+// Key returns the RSA key for the kid, retired keys return nil
+func (k *RSAKeyring) Key(kid interface{}) *rsa.PrivateKey {
+	key, _ := k.key(kid).(*rsa.PrivateKey)
+	return key
+}
+
+// ECDSAKeyring is a Keyring of ECDSA keys implementing ECDSAKeyGen
+type ECDSAKeyring struct {
+	*Keyring
+	curve elliptic.Curve
+}
+
+// NewECDSAKeyring creates an empty ECDSAKeyring generating keys on the given
+// curve, call Rotate or Add to add the first key. Keys on other curves are
+// rejected with ErrKeyAlgorithmMismatch.
+func NewECDSAKeyring(curve elliptic.Curve, options KeyringOptions) *ECDSAKeyring {
+	return &ECDSAKeyring{
+		Keyring: newKeyring(func() (crypto.PrivateKey, error) {
+			return ecdsa.GenerateKey(curve, rand.Reader)
+		}, func(key crypto.PrivateKey) error {
+			ecdsaKey, ok := key.(*ecdsa.PrivateKey)
+			if !ok {
+				return ErrKeyTypeInvalid
+			}
+			if ecdsaKey.Curve != curve {
+				return ErrKeyAlgorithmMismatch
+			}
+			return nil
+		}, options),
+		curve: curve,
+	}
+}
+
+// Curve returns the curve of the keys
+func (k *ECDSAKeyring) Curve() elliptic.Curve {
+	return k.curve
+}
+
+// Key returns the ECDSA key for the kid, retired keys return nil
+func (k *ECDSAKeyring) Key(kid interface{}) *ecdsa.PrivateKey {
+	key, _ := k.key(kid).(*ecdsa.PrivateKey)
+	return key
+}
+
+// Ed25519Keyring is a Keyring of ed25519 keys implementing Ed25519KeyGen
+type Ed25519Keyring struct {
+	*Keyring
+}
+
+// NewEd25519Keyring creates an empty Ed25519Keyring, call Rotate or Add to add
+// the first key
+func NewEd25519Keyring(options KeyringOptions) *Ed25519Keyring {
+	return &Ed25519Keyring{
+		Keyring: newKeyring(func() (crypto.PrivateKey, error) {
+			_, key, err := ed25519.GenerateKey(rand.Reader)
+			return key, err
+		}, func(key crypto.PrivateKey) error {
+			if _, ok := key.(ed25519.PrivateKey); !ok {
+				return ErrKeyTypeInvalid
+			}
+			return nil
+		}, options),
+	}
+}
+
+// Key returns the ed25519 key for the kid, retired keys return nil
+func (k *Ed25519Keyring) Key(kid interface{}) ed25519.PrivateKey {
+	key, _ := k.key(kid).(ed25519.PrivateKey)
+	return key
+}
@@ -0,0 +1,312 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestKeyring(t *testing.T) {
+	if !testing.Verbose() {
+		t.Parallel()
+	}
+
+	Convey("Given a RSA keyring", t, func() {
+		var mutex sync.Mutex
+		changes := make(map[string][]KeyState)
+		times := make(map[string]time.Time)
+
+		keyring, err := NewRSAKeyring(2048, KeyringOptions{
+			VerifyPeriod: time.Hour,
+			OnStateChange: func(kid string, key crypto.PrivateKey, state KeyState, changed time.Time) {
+				mutex.Lock()
+				defer mutex.Unlock()
+				changes[kid] = append(changes[kid], state)
+				times[kid] = changed
+			},
+		})
+		So(err, ShouldBeNil)
+
+		method, err := SigningMethodRSA(keyring, Size256)
+		So(err, ShouldBeNil)
+		generator := NewTokenGenerator(method)
+
+		Convey("When it is rotated for the first time", func() {
+			err := keyring.Rotate()
+			So(err, ShouldBeNil)
+
+			Convey("Then there should be an active and a pending key", func() {
+				kids := keyring.KIDs()
+				So(kids, ShouldHaveLength, 2)
+
+				active := keyring.KID().(string)
+				So(keyring.State(active), ShouldEqual, KeyActive)
+				So(changes[active], ShouldResemble, []KeyState{KeyActive})
+
+				for _, kid := range kids {
+					if kid != active {
+						So(keyring.State(kid.(string)), ShouldEqual, KeyPending)
+					}
+				}
+			})
+		})
+
+		Convey("When a token is signed before rotation", func() {
+			So(keyring.Rotate(), ShouldBeNil)
+			before := keyring.KID().(string)
+
+			str, err := generator.Sign(generator.Create())
+			So(err, ShouldBeNil)
+
+			So(keyring.Rotate(), ShouldBeNil)
+			after := keyring.KID().(string)
+
+			Convey("Then the previously pending key should be active", func() {
+				So(after, ShouldNotEqual, before)
+				So(keyring.State(before), ShouldEqual, KeyVerifyOnly)
+				So(changes[before], ShouldResemble, []KeyState{KeyActive, KeyVerifyOnly})
+				So(changes[after], ShouldResemble, []KeyState{KeyPending, KeyActive})
+			})
+
+			Convey("Then the token should still verify", func() {
+				_, err := generator.Verify(str)
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then new tokens should be signed with the active key", func() {
+				token := generator.Create()
+				_, err := generator.Sign(token)
+				So(err, ShouldBeNil)
+				So(token.Header["kid"], ShouldEqual, after)
+			})
+
+			Convey("Then the token should not verify once its key is retired", func() {
+				err := keyring.SetState(before, KeyRetired)
+				So(err, ShouldBeNil)
+				So(keyring.State(before), ShouldEqual, KeyRetired)
+
+				parsed, err := generator.Verify(str)
+				So(parsed, ShouldBeNil)
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When an existing key is added as active", func() {
+			So(keyring.Rotate(), ShouldBeNil)
+			previous := keyring.KID().(string)
+
+			key, err := rsa.GenerateKey(rand.Reader, 2048)
+			So(err, ShouldBeNil)
+
+			err = keyring.Add("restored", key, KeyActive)
+			So(err, ShouldBeNil)
+
+			Convey("Then it should be used for signing", func() {
+				So(keyring.KID(), ShouldEqual, "restored")
+				So(keyring.Key("restored"), ShouldEqual, key)
+				So(keyring.State(previous), ShouldEqual, KeyVerifyOnly)
+			})
+
+			Convey("Then the state changes should be reported", func() {
+				So(changes["restored"], ShouldResemble, []KeyState{KeyActive})
+				So(changes[previous], ShouldResemble, []KeyState{KeyActive, KeyVerifyOnly})
+			})
+
+			Convey("Then adding it again should fail", func() {
+				err := keyring.Add("restored", key, KeyActive)
+				So(err, ShouldEqual, ErrKeyExists)
+			})
+		})
+
+		Convey("When a verify-only key is restored with the time it changed", func() {
+			key, err := rsa.GenerateKey(rand.Reader, 2048)
+			So(err, ShouldBeNil)
+
+			changed := time.Now().Add(-2 * time.Hour)
+			So(keyring.AddAt("restored", key, KeyVerifyOnly, changed), ShouldBeNil)
+
+			Convey("Then the change time should be reported", func() {
+				So(times["restored"], ShouldEqual, changed)
+			})
+
+			Convey("Then its verify period should not restart", func() {
+				keyring.Prune()
+				So(keyring.State("restored"), ShouldEqual, KeyRetired)
+			})
+		})
+
+		Convey("When an unknown key state is changed", func() {
+			err := keyring.SetState("unknown", KeyActive)
+
+			Convey("Then an error should be returned", func() {
+				So(err, ShouldEqual, ErrKeyNotFound)
+			})
+		})
+
+		Convey("When a key of another type is added", func() {
+			ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			So(err, ShouldBeNil)
+
+			err = keyring.Add("ecdsa", ecdsaKey, KeyActive)
+
+			Convey("Then ErrKeyTypeInvalid should be returned and the key not added", func() {
+				So(err, ShouldEqual, ErrKeyTypeInvalid)
+				So(keyring.State("ecdsa"), ShouldEqual, KeyRetired)
+				So(keyring.KIDs(), ShouldBeEmpty)
+			})
+		})
+
+		Convey("When a key that is too small is added", func() {
+			small, err := rsa.GenerateKey(rand.Reader, 1024)
+			So(err, ShouldBeNil)
+
+			err = keyring.Add("small", small, KeyActive)
+
+			Convey("Then ErrKeyTooSmall should be returned and the key not added", func() {
+				So(err, ShouldEqual, ErrKeyTooSmall)
+				So(keyring.KIDs(), ShouldBeEmpty)
+			})
+		})
+
+		Convey("When a token with an unknown kid is verified", func() {
+			So(keyring.Rotate(), ShouldBeNil)
+
+			otherKeyring, err := NewRSAKeyring(2048, KeyringOptions{})
+			So(err, ShouldBeNil)
+			So(otherKeyring.Rotate(), ShouldBeNil)
+			otherMethod, err := SigningMethodRSA(otherKeyring, Size256)
+			So(err, ShouldBeNil)
+			other := NewTokenGenerator(otherMethod)
+
+			str, err := other.Sign(other.Create())
+			So(err, ShouldBeNil)
+
+			parsed, err := generator.Verify(str)
+
+			Convey("Then an error should be returned", func() {
+				So(parsed, ShouldBeNil)
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+
+	Convey("Given a ECDSA keyring with no verify period", t, func() {
+		keyring := NewECDSAKeyring(elliptic.P256(), KeyringOptions{})
+		method, err := SigningMethodECDSA(keyring, Size256)
+		So(err, ShouldBeNil)
+		generator := NewTokenGenerator(method)
+
+		So(keyring.Rotate(), ShouldBeNil)
+		first := keyring.KID().(string)
+
+		Convey("When it is rotated", func() {
+			So(keyring.Rotate(), ShouldBeNil)
+
+			Convey("Then the previous key should be retired", func() {
+				So(keyring.State(first), ShouldEqual, KeyRetired)
+				So(keyring.Key(first), ShouldBeNil)
+				So(keyring.KIDs(), ShouldHaveLength, 2)
+			})
+
+			Convey("Then tokens should be signed and verified", func() {
+				str, err := generator.Sign(generator.Create())
+				So(err, ShouldBeNil)
+
+				_, err = generator.Verify(str)
+				So(err, ShouldBeNil)
+			})
+		})
+	})
+
+	Convey("Given a RSA keyring size below MinRSAKeySize", t, func() {
+		bits := 1024
+
+		Convey("When the keyring is created", func() {
+			keyring, err := NewRSAKeyring(bits, KeyringOptions{})
+
+			Convey("Then ErrKeyTooSmall should be returned", func() {
+				So(keyring, ShouldBeNil)
+				So(err, ShouldEqual, ErrKeyTooSmall)
+			})
+		})
+	})
+
+	Convey("Given a P-384 ECDSA keyring", t, func() {
+		keyring := NewECDSAKeyring(elliptic.P384(), KeyringOptions{})
+
+		Convey("When it is used with a different size while empty", func() {
+			_, err := SigningMethodECDSA(keyring, Size256)
+
+			Convey("Then ErrKeyAlgorithmMismatch should be returned", func() {
+				So(err, ShouldEqual, ErrKeyAlgorithmMismatch)
+			})
+		})
+
+		Convey("When a key on another curve is added", func() {
+			key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			So(err, ShouldBeNil)
+
+			err = keyring.Add("p256", key, KeyActive)
+
+			Convey("Then ErrKeyAlgorithmMismatch should be returned", func() {
+				So(err, ShouldEqual, ErrKeyAlgorithmMismatch)
+				So(keyring.KIDs(), ShouldBeEmpty)
+			})
+		})
+	})
+
+	Convey("Given a Ed25519 keyring", t, func() {
+		keyring := NewEd25519Keyring(KeyringOptions{VerifyPeriod: time.Hour})
+		method, err := SigningMethodEd25519(keyring)
+		So(err, ShouldBeNil)
+		generator := NewTokenGenerator(method)
+
+		So(keyring.Rotate(), ShouldBeNil)
+
+		Convey("When a token is signed and the keys are rotated", func() {
+			str, err := generator.Sign(generator.Create())
+			So(err, ShouldBeNil)
+
+			So(keyring.Rotate(), ShouldBeNil)
+
+			Convey("Then the token should still verify", func() {
+				_, err := generator.Verify(str)
+				So(err, ShouldBeNil)
+			})
+		})
+	})
+
+	Convey("Given a keyring rotating on a schedule", t, func() {
+		rotated := make(chan string, 10)
+		keyring := NewECDSAKeyring(elliptic.P256(), KeyringOptions{
+			RotationInterval: 10 * time.Millisecond,
+			VerifyPeriod:     time.Hour,
+			OnStateChange: func(kid string, key crypto.PrivateKey, state KeyState, changed time.Time) {
+				if state == KeyActive {
+					select {
+					case rotated <- kid:
+					default:
+					}
+				}
+			},
+		})
+
+		Convey("When it is started", func() {
+			keyring.Start()
+			Reset(keyring.Stop)
+
+			Convey("Then keys should be activated", func() {
+				first := <-rotated
+				second := <-rotated
+				So(second, ShouldNotEqual, first)
+			})
+		})
+	})
+}
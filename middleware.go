@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/pquerna/ffjson/ffjson"
+)
+
+// Errors returned from middleware
+var (
+	ErrTokenMissing       = errors.New("Token is missing")
+	ErrClientTokenRefused = errors.New("Client tokens are not accepted")
+)
+
+// WithClientTokens makes Middleware accept tokens issued to OAuth 2.0 clients
+// with the client_credentials grant. They are refused by default as the user
+// in the context has no UID, see User.IsClient.
+func WithClientTokens() HandlerOption {
+	return func(h *Handler) {
+		h.clientTokens = true
+	}
+}
+
+// Middleware validates the token of requests and stores the user in the
+// request context, see UserFromContext. Only form encoded bodies are read for
+// tokens, other bodies are left to the next handler. Requests without a valid token are
+// rejected with 401 and a WWW-Authenticate header as described in RFC 6750.
+func (h *Handler) Middleware(next http.Handler) http.Handler {
+	return h.middleware(next, false)
+}
+
+// OptionalMiddleware is Middleware that allows requests without a token, they
+// are passed on without a user in their context. Requests with an invalid
+// token are still rejected.
+func (h *Handler) OptionalMiddleware(next http.Handler) http.Handler {
+	return h.middleware(next, true)
+}
+
+func (h *Handler) middleware(next http.Handler, optional bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := requestToken(r, h.sources)
+		if err != nil {
+			writeBearerError(w, http.StatusBadRequest, "invalid_request", err.Error(), CodeRequestInvalid)
+			return
+		}
+
+		if token == "" {
+			if optional {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// RFC 6750 section 3.1, no error code when the token is missing
+			writeBearerError(w, http.StatusUnauthorized, "", ErrTokenMissing.Error(), CodeTokenMissing)
+			return
+		}
+
+		user, err := h.auth.ValidateToken(token)
+		if err != nil {
+			code := ErrorCode(err)
+			if code == CodeError {
+				// A store failed, the token may be valid
+				writeBearerError(w, http.StatusServiceUnavailable, "", "Token could not be validated", code)
+				return
+			}
+
+			writeBearerError(w, http.StatusUnauthorized, "invalid_token", err.Error(), code)
+			return
+		}
+
+		if user.IsClient() && !h.clientTokens {
+			writeBearerError(w, http.StatusUnauthorized, "invalid_token", ErrClientTokenRefused.Error(), CodeClientTokenRefused)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(NewUserContext(r.Context(), user)))
+	})
+}
+
+// writeBearerError writes an error response with a WWW-Authenticate header,
+// the RFC 6750 error code is left out if the request had no token
+func writeBearerError(w http.ResponseWriter, status int, bearer string, description string, code string) {
+	if status == http.StatusUnauthorized || bearer != "" {
+		challenge := "Bearer"
+		if bearer != "" {
+			challenge += fmt.Sprintf(` error="%s", error_description="%s"`, bearer, bearerQuote(description))
+		}
+		w.Header().Set("WWW-Authenticate", challenge)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	encoder := ffjson.NewEncoder(w)
+	encoder.Encode(&Response{
+		Error: description,
+		Code:  code,
+	})
+}
+
+// bearerQuote removes the characters RFC 6750 does not allow in error
+// descriptions
+func bearerQuote(description string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e || r == '"' || r == '\\' {
+			return -1
+		}
+		return r
+	}, description)
+}
@@ -0,0 +1,280 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type failingRevocationStore struct{}
+
+func (failingRevocationStore) Revoke(jti string, expires time.Time) error {
+	return errors.New("Store failed")
+}
+
+func (failingRevocationStore) RevokeUser(uid string, before time.Time) error {
+	return errors.New("Store failed")
+}
+
+func (failingRevocationStore) Revoked(jti string, uid string, issued time.Time) (bool, error) {
+	return false, errors.New("Store failed")
+}
+
+func TestHandlerMiddleware(t *testing.T) {
+	if !testing.Verbose() {
+		t.Parallel()
+	}
+
+	Convey("Given a handler with middleware", t, func() {
+		handler := NewMockHandler()
+
+		var user *User
+		var found bool
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, found = UserFromContext(r.Context())
+			w.WriteHeader(http.StatusTeapot)
+		})
+
+		required := handler.Middleware(next)
+		optional := handler.OptionalMiddleware(next)
+
+		token, err := handler.auth.Generate(NewMockUser("test_uid", "permission1"))
+		So(err, ShouldBeNil)
+
+		decode := func(w *httptest.ResponseRecorder) map[string]string {
+			body := make(map[string]string)
+			So(json.NewDecoder(w.Body).Decode(&body), ShouldBeNil)
+			return body
+		}
+
+		Convey("When a request has a valid token", func() {
+			r := bearerRequest(token)
+			w := httptest.NewRecorder()
+			required.ServeHTTP(w, r)
+
+			Convey("Then the user should be in the request context", func() {
+				So(w.Code, ShouldEqual, http.StatusTeapot)
+				So(found, ShouldBeTrue)
+				So(user.UID, ShouldEqual, "test_uid")
+				So(user.Permissions, ShouldResemble, []string{"permission1"})
+			})
+		})
+
+		Convey("When a request has no token", func() {
+			r := httptest.NewRequest("GET", "/", nil)
+			w := httptest.NewRecorder()
+			required.ServeHTTP(w, r)
+
+			Convey("Then it should be rejected without an error code", func() {
+				So(w.Code, ShouldEqual, http.StatusUnauthorized)
+				So(w.Header().Get("WWW-Authenticate"), ShouldEqual, "Bearer")
+				So(decode(w)["code"], ShouldEqual, CodeTokenMissing)
+				So(found, ShouldBeFalse)
+			})
+		})
+
+		Convey("When a request has an invalid token", func() {
+			r := bearerRequest("invalid")
+			w := httptest.NewRecorder()
+			required.ServeHTTP(w, r)
+
+			Convey("Then it should be rejected with invalid_token", func() {
+				So(w.Code, ShouldEqual, http.StatusUnauthorized)
+				So(w.Header().Get("WWW-Authenticate"), ShouldStartWith, `Bearer error="invalid_token", error_description="`)
+				So(w.Header().Get("Content-Type"), ShouldEqual, "application/json")
+				So(decode(w)["code"], ShouldEqual, CodeTokenMalformed)
+			})
+		})
+
+		Convey("When a request has an expired token", func() {
+			auth := NewAuthenticator(handler.auth.generator, nil, time.Hour, time.Hour)
+			claims, err := auth.claims(NewMockUser("test_uid"), "token", &session{authTime: time.Now()}, time.Now().Add(-2*time.Hour))
+			So(err, ShouldBeNil)
+			claims.ExpiresAt = time.Now().Add(-time.Hour).Unix()
+			expired, err := auth.sign(context.Background(), claims)
+			So(err, ShouldBeNil)
+
+			r := bearerRequest(expired)
+			w := httptest.NewRecorder()
+			required.ServeHTTP(w, r)
+
+			Convey("Then it should be rejected with invalid_token", func() {
+				So(w.Code, ShouldEqual, http.StatusUnauthorized)
+				So(w.Header().Get("WWW-Authenticate"), ShouldEqual, `Bearer error="invalid_token", error_description="Token has expired"`)
+				So(decode(w)["code"], ShouldEqual, CodeTokenExpired)
+			})
+		})
+
+		Convey("When a request has a refresh token", func() {
+			refresh, err := handler.auth.GenerateRefresh(NewMockUser("test_uid"))
+			So(err, ShouldBeNil)
+
+			r := bearerRequest(refresh)
+			w := httptest.NewRecorder()
+			required.ServeHTTP(w, r)
+
+			Convey("Then it should be rejected", func() {
+				So(w.Code, ShouldEqual, http.StatusUnauthorized)
+				So(decode(w)["code"], ShouldEqual, CodeTokenTypeInvalid)
+			})
+		})
+
+		Convey("When a request has no token and the middleware is optional", func() {
+			r := httptest.NewRequest("GET", "/", nil)
+			w := httptest.NewRecorder()
+			optional.ServeHTTP(w, r)
+
+			Convey("Then it should be passed on without a user", func() {
+				So(w.Code, ShouldEqual, http.StatusTeapot)
+				So(found, ShouldBeFalse)
+			})
+		})
+
+		Convey("When a request has an invalid token and the middleware is optional", func() {
+			r := bearerRequest("invalid")
+			w := httptest.NewRecorder()
+			optional.ServeHTTP(w, r)
+
+			Convey("Then it should be rejected", func() {
+				So(w.Code, ShouldEqual, http.StatusUnauthorized)
+				So(found, ShouldBeFalse)
+			})
+		})
+
+		Convey("When a request with a valid token has an application body", func() {
+			var body []byte
+			read := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ = ioutil.ReadAll(r.Body)
+				w.WriteHeader(http.StatusTeapot)
+			})
+
+			for _, data := range []string{`[1,2,3]`, `{"token": 5}`, `{"token": "other"}`} {
+				r := bearerRequest(token)
+				r.Method = "POST"
+				r.Body = ioutil.NopCloser(strings.NewReader(data))
+				r.Header.Set("Content-Type", "application/json")
+
+				w := httptest.NewRecorder()
+				handler.Middleware(read).ServeHTTP(w, r)
+
+				Convey("Then the body should be left to the handler "+data, func() {
+					So(w.Code, ShouldEqual, http.StatusTeapot)
+					So(string(body), ShouldEqual, data)
+				})
+			}
+		})
+
+		Convey("When a request has the token in a form body", func() {
+			r := httptest.NewRequest("POST", "/", strings.NewReader(url.Values{"access_token": []string{token}}.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+			w := httptest.NewRecorder()
+			required.ServeHTTP(w, r)
+
+			Convey("Then the user should be in the request context", func() {
+				So(w.Code, ShouldEqual, http.StatusTeapot)
+				So(user.UID, ShouldEqual, "test_uid")
+			})
+		})
+
+		Convey("When the token can not be validated", func() {
+			handler.auth.revocations = failingRevocationStore{}
+
+			r := bearerRequest(token)
+			w := httptest.NewRecorder()
+			required.ServeHTTP(w, r)
+
+			Convey("Then a server error should be returned", func() {
+				So(w.Code, ShouldEqual, http.StatusServiceUnavailable)
+				So(w.Header().Get("WWW-Authenticate"), ShouldBeEmpty)
+				So(decode(w)["code"], ShouldEqual, CodeError)
+			})
+		})
+	})
+}
+
+func TestHandlerTokenSources(t *testing.T) {
+	if !testing.Verbose() {
+		t.Parallel()
+	}
+
+	Convey("Given a handler that accepts tokens in the query", t, func() {
+		handler := NewMockHandler()
+		handler = NewHandler(handler.auth, WithTokenSources(TokenSourceHeader|TokenSourceQuery))
+
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		})
+		middleware := handler.Middleware(next)
+
+		token, err := handler.auth.Generate(NewMockUser("test_uid"))
+		So(err, ShouldBeNil)
+
+		Convey("When a request has the token in the query", func() {
+			w := httptest.NewRecorder()
+			middleware.ServeHTTP(w, httptest.NewRequest("GET", "/?access_token="+token, nil))
+
+			Convey("Then the request should be allowed", func() {
+				So(w.Code, ShouldEqual, http.StatusTeapot)
+			})
+		})
+
+		Convey("When a request has the token in the query and the header", func() {
+			r := bearerRequest(token)
+			r.URL.RawQuery = "access_token=" + token
+
+			w := httptest.NewRecorder()
+			middleware.ServeHTTP(w, r)
+
+			Convey("Then the request should be rejected with invalid_request", func() {
+				So(w.Code, ShouldEqual, http.StatusBadRequest)
+				So(w.Header().Get("WWW-Authenticate"), ShouldStartWith, `Bearer error="invalid_request"`)
+			})
+		})
+
+		Convey("When a request has the token in the legacy header", func() {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("Bearer", token)
+
+			w := httptest.NewRecorder()
+			middleware.ServeHTTP(w, r)
+
+			Convey("Then the token should be ignored", func() {
+				So(w.Code, ShouldEqual, http.StatusUnauthorized)
+			})
+		})
+	})
+}
+
+func bearerRequest(token string) *http.Request {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+func TestBearerQuote(t *testing.T) {
+	if !testing.Verbose() {
+		t.Parallel()
+	}
+
+	Convey("Given a description with characters not allowed by RFC 6750", t, func() {
+		description := "Token \"claim\" is\\ invalid\n"
+
+		Convey("When it is quoted", func() {
+			quoted := bearerQuote(description)
+
+			Convey("Then the characters should be removed", func() {
+				So(quoted, ShouldEqual, "Token claim is invalid")
+			})
+		})
+	})
+}
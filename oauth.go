@@ -0,0 +1,269 @@
+package auth
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pquerna/ffjson/ffjson"
+)
+
+// OAuth 2.0 error codes, RFC 6749 section 5.2
+const (
+	oauthInvalidRequest       = "invalid_request"
+	oauthInvalidClient        = "invalid_client"
+	oauthInvalidGrant         = "invalid_grant"
+	oauthInvalidScope         = "invalid_scope"
+	oauthUnauthorizedClient   = "unauthorized_client"
+	oauthUnsupportedGrantType = "unsupported_grant_type"
+	oauthServerError          = "server_error"
+)
+
+// WithOAuth2 makes the handler an OAuth 2.0 token endpoint as described in
+// RFC 6749, it accepts the password and refresh_token grants
+func WithOAuth2() HandlerOption {
+	return func(h *Handler) {
+		h.oauth2 = true
+	}
+}
+
+// WithClients sets the OAuth 2.0 clients of the token endpoint, it enables the
+// client_credentials grant. Clients authenticate with HTTP Basic or the
+// client_id and client_secret parameters. Requests without client credentials
+// can still use the password and refresh_token grants, tokens from a client are
+// limited to its scopes and its refresh tokens can only be used by it.
+func WithClients(registry ClientRegistry) HandlerOption {
+	return func(h *Handler) {
+		h.oauth2 = true
+		h.clients = registry
+	}
+}
+
+// oauthToken is a successful token response, RFC 6749 section 5.1
+type oauthToken struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// serveToken handles OAuth 2.0 token requests
+func (h *Handler) serveToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		writeOAuthError(w, http.StatusMethodNotAllowed, oauthInvalidRequest, "Token requests must use POST")
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidRequest, "The request body is invalid")
+		return
+	}
+
+	// Parameters must not be repeated, RFC 6749 section 3.2
+	for name, values := range r.PostForm {
+		if len(values) > 1 {
+			writeOAuthError(w, http.StatusBadRequest, oauthInvalidRequest, "The "+name+" parameter is repeated")
+			return
+		}
+	}
+
+	client, ok := h.authenticateClient(w, r)
+	if !ok {
+		return
+	}
+
+	grant := r.PostForm.Get("grant_type")
+	if client != nil && grant != "" && !client.AllowsGrant(grant) {
+		writeOAuthError(w, http.StatusBadRequest, oauthUnauthorizedClient, ErrGrantNotAllowed.Error())
+		return
+	}
+
+	var token, refresh string
+	var err error
+
+	switch grant {
+	case "password":
+		username, password := r.PostForm.Get("username"), r.PostForm.Get("password")
+		if username == "" || password == "" {
+			writeOAuthError(w, http.StatusBadRequest, oauthInvalidRequest, "The username and password parameters are required")
+			return
+		}
+		if client != nil {
+			token, refresh, err = h.auth.AuthenticateClient(r.Context(), r, client, username, password)
+		} else {
+			token, refresh, err = h.auth.AuthenticateContext(r.Context(), r, username, password)
+		}
+
+	case "refresh_token":
+		previous := r.PostForm.Get("refresh_token")
+		if previous == "" {
+			writeOAuthError(w, http.StatusBadRequest, oauthInvalidRequest, "The refresh_token parameter is required")
+			return
+		}
+		token, refresh, err = h.auth.RefreshClient(r.Context(), previous, client)
+
+	case "client_credentials":
+		if h.clients == nil {
+			writeOAuthError(w, http.StatusBadRequest, oauthUnsupportedGrantType, "The grant type is not supported")
+			return
+		}
+
+		// Public clients can not prove who they are
+		if client == nil || client.SecretHash == "" {
+			writeClientError(w)
+			return
+		}
+
+		scopes := strings.Fields(r.PostForm.Get("scope"))
+		if len(scopes) == 0 {
+			scopes = client.Scopes
+		}
+		for _, scope := range scopes {
+			if !client.AllowsScope(scope) {
+				writeOAuthError(w, http.StatusBadRequest, oauthInvalidScope, ErrScopeNotAllowed.Error())
+				return
+			}
+		}
+
+		token, err = h.auth.GenerateClient(r.Context(), client, scopes)
+
+	case "":
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidRequest, "The grant_type parameter is required")
+		return
+
+	default:
+		writeOAuthError(w, http.StatusBadRequest, oauthUnsupportedGrantType, "The grant type is not supported")
+		return
+	}
+
+	if err != nil {
+		writeGrantError(w, err)
+		return
+	}
+
+	response, err := h.oauthToken(token, refresh)
+	if err != nil {
+		writeGrantError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	encoder := ffjson.NewEncoder(w)
+	encoder.Encode(response)
+}
+
+// authenticateClient authenticates the client of a token request, the client
+// is nil if the request has no client credentials. ok is false if an error
+// response was written.
+func (h *Handler) authenticateClient(w http.ResponseWriter, r *http.Request) (client *Client, ok bool) {
+	if h.clients == nil {
+		return nil, true
+	}
+
+	id, secret, basic := r.BasicAuth()
+	if basic {
+		// Credentials must be sent once, RFC 6749 section 2.3
+		if r.PostForm.Get("client_id") != "" || r.PostForm.Get("client_secret") != "" {
+			writeOAuthError(w, http.StatusBadRequest, oauthInvalidRequest, "Client credentials must be sent once")
+			return nil, false
+		}
+
+		// Basic credentials are form encoded, RFC 6749 section 2.3.1
+		var idErr, secretErr error
+		id, idErr = url.QueryUnescape(id)
+		secret, secretErr = url.QueryUnescape(secret)
+		if idErr != nil || secretErr != nil {
+			writeClientError(w)
+			return nil, false
+		}
+	} else {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+
+	if id == "" {
+		if basic || secret != "" {
+			writeClientError(w)
+			return nil, false
+		}
+		return nil, true
+	}
+
+	client, err := h.clients.Client(id)
+	switch {
+	case err == ErrClientNotFound:
+		writeClientError(w)
+		return nil, false
+	case err != nil:
+		writeOAuthError(w, http.StatusServiceUnavailable, oauthServerError, "")
+		return nil, false
+	}
+
+	// Public clients only identify themselves, confidential clients must send
+	// their secret
+	if client.SecretHash != "" || secret != "" {
+		if !client.VerifySecret(secret) {
+			writeClientError(w)
+			return nil, false
+		}
+	}
+
+	return client, true
+}
+
+// writeClientError writes a failed client authentication, RFC 6749 section 5.2
+func writeClientError(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="token"`)
+	writeOAuthError(w, http.StatusUnauthorized, oauthInvalidClient, ErrClientInvalid.Error())
+}
+
+// oauthToken creates the token response, the scope is the permissions of the
+// token
+func (h *Handler) oauthToken(token string, refresh string) (*oauthToken, error) {
+	parsed, err := h.auth.verifier.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := ParseClaims(parsed)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &oauthToken{
+		AccessToken:  token,
+		TokenType:    "Bearer",
+		RefreshToken: refresh,
+		Scope:        strings.Join(h.auth.user(claims).Permissions, " "),
+	}
+
+	if claims.ExpiresAt != 0 {
+		response.ExpiresIn = claims.ExpiresAt - time.Now().Unix()
+	}
+
+	return response, nil
+}
+
+// writeGrantError writes the error of a failed grant, errors from storage and
+// token validation mean the grant is invalid
+func writeGrantError(w http.ResponseWriter, err error) {
+	status := loginStatus(err)
+	if status != http.StatusUnauthorized {
+		writeOAuthError(w, status, oauthServerError, "")
+		return
+	}
+
+	// Storage errors may describe the storage, so they are not returned
+	description := "The credentials are invalid"
+	if ErrorCode(err) != CodeError {
+		description = err.Error()
+	}
+
+	writeOAuthError(w, http.StatusBadRequest, oauthInvalidGrant, description)
+}
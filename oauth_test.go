@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHandlerOAuth2(t *testing.T) {
+	if !testing.Verbose() {
+		t.Parallel()
+	}
+
+	Convey("Given an OAuth 2.0 token endpoint", t, func() {
+		storage := NewMockStorage("test_uid", "test_user", "test_pass", []string{"orders:read", "orders:write"})
+		auth := NewAuthenticator(NewMockTokenGenerator(), storage, time.Hour, time.Hour*24)
+		handler := NewHandler(auth, WithOAuth2())
+
+		post := func(values url.Values) (*httptest.ResponseRecorder, map[string]interface{}) {
+			r := httptest.NewRequest("POST", "/token", strings.NewReader(values.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			body := make(map[string]interface{})
+			So(json.NewDecoder(w.Body).Decode(&body), ShouldBeNil)
+			return w, body
+		}
+
+		Convey("When a password grant is requested", func() {
+			w, body := post(url.Values{
+				"grant_type": []string{"password"},
+				"username":   []string{"test_user"},
+				"password":   []string{"test_pass"},
+			})
+
+			Convey("Then a token response should be returned", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(w.Header().Get("Content-Type"), ShouldEqual, "application/json")
+				So(w.Header().Get("Cache-Control"), ShouldEqual, "no-store")
+				So(w.Header().Get("Pragma"), ShouldEqual, "no-cache")
+
+				So(body["access_token"], ShouldNotBeEmpty)
+				So(body["refresh_token"], ShouldNotBeEmpty)
+				So(body["token_type"], ShouldEqual, "Bearer")
+				So(body["expires_in"], ShouldAlmostEqual, 3600, 1)
+				So(body["scope"], ShouldEqual, "orders:read orders:write")
+			})
+
+			Convey("Then the access token should be valid", func() {
+				user, err := auth.ValidateToken(body["access_token"].(string))
+				So(err, ShouldBeNil)
+				So(user.UID, ShouldEqual, "test_uid")
+			})
+
+			Convey("Then the refresh token can be used in a refresh_token grant", func() {
+				w, refreshed := post(url.Values{
+					"grant_type":    []string{"refresh_token"},
+					"refresh_token": []string{body["refresh_token"].(string)},
+				})
+
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(refreshed["access_token"], ShouldNotBeEmpty)
+				So(refreshed["refresh_token"], ShouldNotBeEmpty)
+			})
+		})
+
+		Convey("When a password grant has invalid credentials", func() {
+			w, body := post(url.Values{
+				"grant_type": []string{"password"},
+				"username":   []string{"test_user"},
+				"password":   []string{"invalid"},
+			})
+
+			Convey("Then invalid_grant should be returned", func() {
+				So(w.Code, ShouldEqual, http.StatusBadRequest)
+				So(body["error"], ShouldEqual, "invalid_grant")
+				So(body["error_description"], ShouldEqual, "The credentials are invalid")
+				So(body, ShouldNotContainKey, "access_token")
+			})
+		})
+
+		Convey("When a refresh_token grant has an access token", func() {
+			token, err := auth.Generate(NewMockUser("test_uid"))
+			So(err, ShouldBeNil)
+
+			w, body := post(url.Values{
+				"grant_type":    []string{"refresh_token"},
+				"refresh_token": []string{token},
+			})
+
+			Convey("Then invalid_grant should be returned", func() {
+				So(w.Code, ShouldEqual, http.StatusBadRequest)
+				So(body["error"], ShouldEqual, "invalid_grant")
+				So(body["error_description"], ShouldEqual, ErrTokenTypeInvalid.Error())
+			})
+		})
+
+		Convey("When invalid requests are made", func() {
+			cases := []struct {
+				values url.Values
+				error  string
+			}{
+				{url.Values{}, "invalid_request"},
+				{url.Values{"grant_type": []string{"password"}, "username": []string{"test_user"}}, "invalid_request"},
+				{url.Values{"grant_type": []string{"refresh_token"}}, "invalid_request"},
+				{url.Values{"grant_type": []string{"password", "password"}, "username": []string{"test_user"}, "password": []string{"test_pass"}}, "invalid_request"},
+				{url.Values{"grant_type": []string{"authorization_code"}, "code": []string{"code"}}, "unsupported_grant_type"},
+			}
+
+			Convey("Then the RFC 6749 errors should be returned", func() {
+				for _, c := range cases {
+					w, body := post(c.values)
+					So(w.Code, ShouldEqual, http.StatusBadRequest)
+					So(body["error"], ShouldEqual, c.error)
+				}
+			})
+		})
+
+		Convey("When the credentials are sent in the query", func() {
+			r := httptest.NewRequest("POST", "/token?grant_type=password&username=test_user&password=test_pass", nil)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			Convey("Then they should be ignored", func() {
+				So(w.Code, ShouldEqual, http.StatusBadRequest)
+				So(w.Body.String(), ShouldContainSubstring, `"invalid_request"`)
+			})
+		})
+
+		Convey("When a GET request is made", func() {
+			r := httptest.NewRequest("GET", "/token", nil)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			Convey("Then the method should not be allowed", func() {
+				So(w.Code, ShouldEqual, http.StatusMethodNotAllowed)
+				So(w.Header().Get("Allow"), ShouldEqual, "POST")
+			})
+		})
+	})
+}
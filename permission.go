@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/pquerna/ffjson/ffjson"
+)
+
+// Errors returned from authorization
+var (
+	ErrPermissionDenied = errors.New("Permission denied")
+)
+
+// HasPermission checks if the user has been granted a permission. Permissions
+// are hierarchical, separated by colons, so orders grants orders:read. A
+// granted permission ending in :* grants everything below it and * grants
+// every permission.
+func (u *User) HasPermission(permission string) bool {
+	for _, granted := range u.Permissions {
+		if permissionGrants(granted, permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasAnyPermission checks if the user has been granted any of the permissions
+func (u *User) HasAnyPermission(permissions ...string) bool {
+	for _, permission := range permissions {
+		if u.HasPermission(permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasAllPermissions checks if the user has been granted all of the
+// permissions
+func (u *User) HasAllPermissions(permissions ...string) bool {
+	for _, permission := range permissions {
+		if !u.HasPermission(permission) {
+			return false
+		}
+	}
+	return true
+}
+
+func permissionGrants(granted string, permission string) bool {
+	switch {
+	case granted == "*", granted == permission:
+		return true
+	case strings.HasSuffix(granted, ":*"):
+		return strings.HasPrefix(permission, granted[:len(granted)-1])
+	}
+	return strings.HasPrefix(permission, granted+":")
+}
+
+// RequirePermission returns middleware that only allows users with the
+// permission, it must be used after Handler.Middleware. It panics if the
+// permission can not be used in a policy.
+func RequirePermission(permission string) func(http.Handler) http.Handler {
+	return RequireAll(permission)
+}
+
+// RequireAny returns middleware that only allows users with any of the
+// permissions, see RequirePermission
+func RequireAny(permissions ...string) func(http.Handler) http.Handler {
+	nodes := permissionNodes(permissions)
+	if len(nodes) == 1 {
+		return RequirePolicy(&Policy{root: nodes[0]})
+	}
+	return RequirePolicy(&Policy{root: orNode(nodes)})
+}
+
+// RequireAll returns middleware that only allows users with all of the
+// permissions, see RequirePermission
+func RequireAll(permissions ...string) func(http.Handler) http.Handler {
+	nodes := permissionNodes(permissions)
+	if len(nodes) == 1 {
+		return RequirePolicy(&Policy{root: nodes[0]})
+	}
+	return RequirePolicy(&Policy{root: andNode(nodes)})
+}
+
+func permissionNodes(permissions []string) []policyNode {
+	if len(permissions) == 0 {
+		panic("auth: no permissions required")
+	}
+
+	nodes := make([]policyNode, len(permissions))
+	for i, permission := range permissions {
+		if !isPermission(permission) {
+			panic(fmt.Sprintf("auth: permission %q is invalid", permission))
+		}
+		nodes[i] = permissionNode(permission)
+	}
+	return nodes
+}
+
+// RequirePolicy returns middleware that only allows users the policy allows,
+// it must be used after Handler.Middleware. Requests without a user are
+// rejected with 401 and users that are not allowed with 403.
+func RequirePolicy(policy *Policy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := UserFromContext(r.Context())
+			if !ok {
+				writeBearerError(w, http.StatusUnauthorized, "", ErrTokenMissing.Error(), CodeTokenMissing)
+				return
+			}
+
+			if !policy.Allow(user) {
+				writeDenied(w, policy)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// deniedResponse is the body of 403 responses
+type deniedResponse struct {
+	Error    string `json:"error"`
+	Code     string `json:"code"`
+	Required string `json:"required"`
+}
+
+// writeDenied writes a 403 response, the WWW-Authenticate header is the
+// insufficient_scope error of RFC 6750
+func writeDenied(w http.ResponseWriter, policy *Policy) {
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", error_description="%s"`, ErrPermissionDenied))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusForbidden)
+
+	encoder := ffjson.NewEncoder(w)
+	encoder.Encode(&deniedResponse{
+		Error:    ErrPermissionDenied.Error(),
+		Code:     CodePermissionDenied,
+		Required: policy.String(),
+	})
+}
@@ -0,0 +1,151 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUserHasPermission(t *testing.T) {
+	if !testing.Verbose() {
+		t.Parallel()
+	}
+
+	Convey("Given granted and required permissions", t, func() {
+		cases := []struct {
+			granted    string
+			permission string
+			allow      bool
+		}{
+			{"orders:read", "orders:read", true},
+			{"orders:read", "orders:write", false},
+			{"orders:*", "orders:write", true},
+			{"orders:*", "orders:items:write", true},
+			{"orders:*", "orders", false},
+			{"orders:*", "ordersx:write", false},
+			{"orders", "orders:read", true},
+			{"orders", "ordersx", false},
+			{"admin", "admin", true},
+			{"admin", "orders:read", false},
+			{"*", "orders:read", true},
+		}
+
+		Convey("When the permissions are checked", func() {
+			Convey("Then they should match hierarchically", func() {
+				for _, c := range cases {
+					user := NewMockUser("test_uid", c.granted)
+					So(user.HasPermission(c.permission), ShouldEqual, c.allow)
+				}
+			})
+		})
+
+		Convey("When several permissions are checked", func() {
+			user := NewMockUser("test_uid", "orders:read", "products:read")
+
+			Convey("Then any and all should be checked", func() {
+				So(user.HasAnyPermission("orders:write", "products:read"), ShouldBeTrue)
+				So(user.HasAnyPermission("orders:write", "products:write"), ShouldBeFalse)
+				So(user.HasAllPermissions("orders:read", "products:read"), ShouldBeTrue)
+				So(user.HasAllPermissions("orders:read", "products:write"), ShouldBeFalse)
+			})
+		})
+	})
+}
+
+func TestRequirePermission(t *testing.T) {
+	if !testing.Verbose() {
+		t.Parallel()
+	}
+
+	Convey("Given authorization middleware", t, func() {
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		})
+
+		serve := func(middleware func(http.Handler) http.Handler, user *User) *httptest.ResponseRecorder {
+			r := httptest.NewRequest("GET", "/", nil)
+			if user != nil {
+				r = r.WithContext(NewUserContext(r.Context(), user))
+			}
+			w := httptest.NewRecorder()
+			middleware(next).ServeHTTP(w, r)
+			return w
+		}
+
+		user := NewMockUser("test_uid", "orders:read", "suspended")
+
+		Convey("When the user has the permission", func() {
+			w := serve(RequirePermission("orders:read"), user)
+
+			Convey("Then the request should be allowed", func() {
+				So(w.Code, ShouldEqual, http.StatusTeapot)
+			})
+		})
+
+		Convey("When the user does not have the permission", func() {
+			w := serve(RequirePermission("orders:write"), user)
+
+			Convey("Then the request should be denied", func() {
+				So(w.Code, ShouldEqual, http.StatusForbidden)
+				So(w.Header().Get("WWW-Authenticate"), ShouldStartWith, `Bearer error="insufficient_scope"`)
+
+				body := make(map[string]string)
+				So(json.NewDecoder(w.Body).Decode(&body), ShouldBeNil)
+				So(body["code"], ShouldEqual, CodePermissionDenied)
+				So(body["error"], ShouldEqual, ErrPermissionDenied.Error())
+				So(body["required"], ShouldEqual, "orders:write")
+			})
+		})
+
+		Convey("When any permission is required", func() {
+			allowed := serve(RequireAny("orders:write", "orders:read"), user)
+			denied := serve(RequireAny("orders:write", "admin"), user)
+
+			Convey("Then one permission should be enough", func() {
+				So(allowed.Code, ShouldEqual, http.StatusTeapot)
+				So(denied.Code, ShouldEqual, http.StatusForbidden)
+				So(denied.Body.String(), ShouldContainSubstring, `"orders:write || admin"`)
+			})
+		})
+
+		Convey("When all permissions are required", func() {
+			allowed := serve(RequireAll("orders:read", "suspended"), user)
+			denied := serve(RequireAll("orders:read", "admin"), user)
+
+			Convey("Then every permission should be needed", func() {
+				So(allowed.Code, ShouldEqual, http.StatusTeapot)
+				So(denied.Code, ShouldEqual, http.StatusForbidden)
+			})
+		})
+
+		Convey("When a policy is required", func() {
+			policy := MustCompilePolicy("orders:read && !suspended")
+			denied := serve(RequirePolicy(policy), user)
+			allowed := serve(RequirePolicy(policy), NewMockUser("test_uid", "orders:*"))
+
+			Convey("Then the policy should be evaluated", func() {
+				So(allowed.Code, ShouldEqual, http.StatusTeapot)
+				So(denied.Code, ShouldEqual, http.StatusForbidden)
+			})
+		})
+
+		Convey("When there is no user in the context", func() {
+			w := serve(RequirePermission("orders:read"), nil)
+
+			Convey("Then the request should be unauthorized", func() {
+				So(w.Code, ShouldEqual, http.StatusUnauthorized)
+				So(w.Header().Get("WWW-Authenticate"), ShouldEqual, "Bearer")
+			})
+		})
+
+		Convey("When invalid permissions are required", func() {
+			Convey("Then the middleware should panic", func() {
+				So(func() { RequirePermission("orders read") }, ShouldPanic)
+				So(func() { RequireAny() }, ShouldPanic)
+			})
+		})
+	})
+}
@@ -0,0 +1,286 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Errors returned from policies
+var (
+	ErrPolicyInvalid = errors.New("Policy is invalid")
+)
+
+// Policy is a compiled boolean expression over permissions, such as
+// "orders:read && !suspended". Permissions are matched with
+// User.HasPermission and can be combined with &&, ||, ! and parentheses.
+// Negated permissions are matched exactly, so a user granted * is not
+// suspended.
+type Policy struct {
+	root policyNode
+}
+
+// CompilePolicy compiles a policy expression
+func CompilePolicy(expr string) (*Policy, error) {
+	p := &policyParser{expr: expr}
+	p.next()
+
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.tok != "" {
+		return nil, p.error("unexpected %q", p.tok)
+	}
+
+	return &Policy{root: root}, nil
+}
+
+// MustCompilePolicy compiles a policy expression and panics if it is invalid
+func MustCompilePolicy(expr string) *Policy {
+	policy, err := CompilePolicy(expr)
+	if err != nil {
+		panic(err)
+	}
+	return policy
+}
+
+// Allow evaluates the policy for a user, a nil user is never allowed
+func (p *Policy) Allow(user *User) bool {
+	if user == nil {
+		return false
+	}
+	return p.root.allow(user, false)
+}
+
+// String returns the policy expression
+func (p *Policy) String() string {
+	return p.root.String()
+}
+
+// policyNode is a node of a compiled policy, negated is true under an odd
+// number of negations
+type policyNode interface {
+	allow(user *User, negated bool) bool
+	String() string
+}
+
+type permissionNode string
+
+func (n permissionNode) allow(user *User, negated bool) bool {
+	if !negated {
+		return user.HasPermission(string(n))
+	}
+
+	// Wildcards and parents would grant the negated permission too
+	for _, granted := range user.Permissions {
+		if granted == string(n) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n permissionNode) String() string {
+	return string(n)
+}
+
+type notNode struct {
+	node policyNode
+}
+
+func (n notNode) allow(user *User, negated bool) bool {
+	return !n.node.allow(user, !negated)
+}
+
+func (n notNode) String() string {
+	switch n.node.(type) {
+	case andNode, orNode:
+		return "!(" + n.node.String() + ")"
+	}
+	return "!" + n.node.String()
+}
+
+type andNode []policyNode
+
+func (n andNode) allow(user *User, negated bool) bool {
+	for _, node := range n {
+		if !node.allow(user, negated) {
+			return false
+		}
+	}
+	return true
+}
+
+func (n andNode) String() string {
+	parts := make([]string, len(n))
+	for i, node := range n {
+		parts[i] = node.String()
+		if _, ok := node.(orNode); ok {
+			parts[i] = "(" + parts[i] + ")"
+		}
+	}
+	return strings.Join(parts, " && ")
+}
+
+type orNode []policyNode
+
+func (n orNode) allow(user *User, negated bool) bool {
+	for _, node := range n {
+		if node.allow(user, negated) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n orNode) String() string {
+	parts := make([]string, len(n))
+	for i, node := range n {
+		parts[i] = node.String()
+	}
+	return strings.Join(parts, " || ")
+}
+
+// policyParser is a recursive descent parser for policy expressions
+type policyParser struct {
+	expr string
+	pos  int
+
+	// tok is the current token and tokPos its offset, tok is empty at the
+	// end of the expression
+	tok    string
+	tokPos int
+}
+
+func (p *policyParser) parseOr() (policyNode, error) {
+	node, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := orNode{node}
+	for p.tok == "||" {
+		p.next()
+		if node, err = p.parseAnd(); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return nodes, nil
+}
+
+func (p *policyParser) parseAnd() (policyNode, error) {
+	node, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := andNode{node}
+	for p.tok == "&&" {
+		p.next()
+		if node, err = p.parseUnary(); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return nodes, nil
+}
+
+func (p *policyParser) parseUnary() (policyNode, error) {
+	switch p.tok {
+	case "!":
+		p.next()
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{node}, nil
+
+	case "(":
+		p.next()
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok != ")" {
+			return nil, p.error("expected )")
+		}
+		p.next()
+		return node, nil
+
+	case "":
+		return nil, p.error("unexpected end")
+	}
+
+	if !isPermission(p.tok) {
+		return nil, p.error("unexpected %q", p.tok)
+	}
+
+	node := permissionNode(p.tok)
+	p.next()
+	return node, nil
+}
+
+// next reads the next token
+func (p *policyParser) next() {
+	for p.pos < len(p.expr) && strings.ContainsRune(" \t\r\n", rune(p.expr[p.pos])) {
+		p.pos++
+	}
+
+	p.tokPos = p.pos
+	if p.pos == len(p.expr) {
+		p.tok = ""
+		return
+	}
+
+	rest := p.expr[p.pos:]
+	switch {
+	case strings.HasPrefix(rest, "&&"), strings.HasPrefix(rest, "||"):
+		p.pos += 2
+	case strings.ContainsRune("!()", rune(rest[0])):
+		p.pos++
+	case isPermissionChar(rest[0]):
+		for p.pos < len(p.expr) && isPermissionChar(p.expr[p.pos]) {
+			p.pos++
+		}
+	default:
+		p.pos++
+	}
+
+	p.tok = p.expr[p.tokPos:p.pos]
+}
+
+func (p *policyParser) error(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s at offset %d", ErrPolicyInvalid, fmt.Sprintf(format, args...), p.tokPos)
+}
+
+func isPermissionChar(c byte) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	}
+	return strings.IndexByte("_-.:*", c) >= 0
+}
+
+// isPermission checks a permission can be used in a policy
+func isPermission(permission string) bool {
+	if permission == "" {
+		return false
+	}
+	for i := 0; i < len(permission); i++ {
+		if !isPermissionChar(permission[i]) {
+			return false
+		}
+	}
+	return true
+}
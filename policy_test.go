@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPolicy(t *testing.T) {
+	if !testing.Verbose() {
+		t.Parallel()
+	}
+
+	Convey("Given a user", t, func() {
+		user := NewMockUser("test_uid", "orders:read", "products:*", "suspended")
+
+		Convey("When policies are evaluated", func() {
+			cases := []struct {
+				expr  string
+				allow bool
+			}{
+				{"orders:read", true},
+				{"orders:write", false},
+				{"products:write", true},
+				{"orders:read && !suspended", false},
+				{"orders:read || orders:write", true},
+				{"orders:write || !suspended", false},
+				{"!(orders:write || admin)", true},
+				{"orders:read && (orders:write || products:read)", true},
+				{"orders:read && orders:write || products:read", true},
+				{"!!orders:read", true},
+				{"!!products:write", true},
+				{"!products:write", true},
+			}
+
+			Convey("Then they should allow the expected users", func() {
+				for _, c := range cases {
+					policy, err := CompilePolicy(c.expr)
+					So(err, ShouldBeNil)
+					So(policy.Allow(user), ShouldEqual, c.allow)
+				}
+			})
+		})
+
+		Convey("When a user granted every permission is evaluated", func() {
+			admin := NewMockUser("admin_uid", "*")
+
+			Convey("Then negated permissions should not match the wildcard", func() {
+				So(MustCompilePolicy("orders:read && !suspended").Allow(admin), ShouldBeTrue)
+				So(MustCompilePolicy("!(suspended || banned)").Allow(admin), ShouldBeTrue)
+				So(MustCompilePolicy("!!suspended").Allow(admin), ShouldBeTrue)
+			})
+		})
+
+		Convey("When a policy is evaluated without a user", func() {
+			policy := MustCompilePolicy("!suspended")
+
+			Convey("Then it should not allow", func() {
+				So(policy.Allow(nil), ShouldBeFalse)
+			})
+		})
+	})
+
+	Convey("Given policy expressions", t, func() {
+		Convey("When they are compiled", func() {
+			cases := map[string]string{
+				"a":                 "a",
+				"a&&b":              "a && b",
+				"(a || b) && c":     "(a || b) && c",
+				"a || b && c":       "a || b && c",
+				"!(a && b)":         "!(a && b)",
+				"!a || (b)":         "!a || b",
+				"orders:* && admin": "orders:* && admin",
+			}
+
+			Convey("Then they should be formatted", func() {
+				for expr, formatted := range cases {
+					So(MustCompilePolicy(expr).String(), ShouldEqual, formatted)
+				}
+			})
+		})
+
+		Convey("When invalid expressions are compiled", func() {
+			invalid := []string{"", "a &&", "(a", "a)", "a & b", "a b", "!", "a || || b", "a$"}
+
+			Convey("Then ErrPolicyInvalid should be returned", func() {
+				for _, expr := range invalid {
+					policy, err := CompilePolicy(expr)
+					So(policy, ShouldBeNil)
+					So(errors.Is(err, ErrPolicyInvalid), ShouldBeTrue)
+				}
+			})
+
+			Convey("Then MustCompilePolicy should panic", func() {
+				So(func() { MustCompilePolicy("a &&") }, ShouldPanic)
+			})
+		})
+	})
+}
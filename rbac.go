@@ -0,0 +1,192 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pquerna/ffjson/ffjson"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Errors returned from Roles
+var (
+	ErrRoleUnknown    = errors.New("Role is unknown")
+	ErrRoleCycle      = errors.New("Role inherits itself")
+	ErrRolesNotLoaded = errors.New("Roles were not loaded from a file")
+)
+
+// Role is a named set of permissions, a role has the permissions of the roles
+// it inherits
+type Role struct {
+	Name        string
+	Permissions []string
+	Inherits    []string
+}
+
+// Roles expands role names into permissions, so tokens can carry roles
+// instead of every permission. It is safe to change the roles while they are
+// in use.
+type Roles struct {
+	path  string
+	mutex sync.RWMutex
+
+	// permissions maps role names to their permissions, including inherited
+	// permissions
+	permissions map[string][]string
+}
+
+// NewRoles creates Roles from role definitions
+func NewRoles(roles ...Role) (*Roles, error) {
+	r := &Roles{}
+	if err := r.Set(roles...); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// LoadRoles creates Roles from a YAML or JSON file, files with a .json
+// extension are JSON. Reload reads the file again.
+//
+//	roles:
+//	  editor:
+//	    permissions: [orders:read, orders:write]
+//	  admin:
+//	    inherits: [editor]
+//	    permissions: [users]
+func LoadRoles(path string) (*Roles, error) {
+	r := &Roles{path: path}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// roleFile is the format of role files
+type roleFile struct {
+	Roles map[string]struct {
+		Permissions []string `json:"permissions" yaml:"permissions"`
+		Inherits    []string `json:"inherits" yaml:"inherits"`
+	} `json:"roles" yaml:"roles"`
+}
+
+// Reload reads the roles from the file they were loaded from again, if the
+// file is invalid the current roles are kept
+func (r *Roles) Reload() error {
+	if r.path == "" {
+		return ErrRolesNotLoaded
+	}
+
+	data, err := ioutil.ReadFile(r.path)
+	if err != nil {
+		return err
+	}
+
+	var file roleFile
+	if strings.EqualFold(filepath.Ext(r.path), ".json") {
+		err = ffjson.Unmarshal(data, &file)
+	} else {
+		err = yaml.Unmarshal(data, &file)
+	}
+	if err != nil {
+		return err
+	}
+
+	roles := make([]Role, 0, len(file.Roles))
+	for name, role := range file.Roles {
+		roles = append(roles, Role{
+			Name:        name,
+			Permissions: role.Permissions,
+			Inherits:    role.Inherits,
+		})
+	}
+
+	return r.Set(roles...)
+}
+
+// Set replaces the roles, if a role inherits an unknown role or itself an
+// error is returned and the current roles are kept
+func (r *Roles) Set(roles ...Role) error {
+	definitions := make(map[string]Role, len(roles))
+	for _, role := range roles {
+		definitions[role.Name] = role
+	}
+
+	permissions := make(map[string][]string, len(roles))
+	for _, role := range roles {
+		if _, err := resolveRole(definitions, permissions, role.Name, nil); err != nil {
+			return err
+		}
+	}
+
+	r.mutex.Lock()
+	r.permissions = permissions
+	r.mutex.Unlock()
+
+	return nil
+}
+
+// resolveRole returns the permissions of a role including inherited
+// permissions, path is the roles being resolved to detect cycles
+func resolveRole(definitions map[string]Role, resolved map[string][]string, name string, path []string) ([]string, error) {
+	if permissions, ok := resolved[name]; ok {
+		return permissions, nil
+	}
+
+	for _, parent := range path {
+		if parent == name {
+			return nil, fmt.Errorf("%w: %s", ErrRoleCycle, name)
+		}
+	}
+
+	role, ok := definitions[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrRoleUnknown, name)
+	}
+
+	permissions := appendUnique(nil, role.Permissions...)
+	for _, inherited := range role.Inherits {
+		inheritedPermissions, err := resolveRole(definitions, resolved, inherited, append(path, name))
+		if err != nil {
+			return nil, err
+		}
+		permissions = appendUnique(permissions, inheritedPermissions...)
+	}
+
+	resolved[name] = permissions
+	return permissions, nil
+}
+
+// Permissions returns the permissions of the roles, unknown roles have no
+// permissions
+func (r *Roles) Permissions(roles ...string) []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	permissions := []string{}
+	for _, role := range roles {
+		permissions = appendUnique(permissions, r.permissions[role]...)
+	}
+	return permissions
+}
+
+// appendUnique appends the values that are not already in the slice
+func appendUnique(slice []string, values ...string) []string {
+	for _, value := range values {
+		found := false
+		for _, existing := range slice {
+			if existing == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			slice = append(slice, value)
+		}
+	}
+	return slice
+}
@@ -0,0 +1,223 @@
+package auth
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRoles(t *testing.T) {
+	if !testing.Verbose() {
+		t.Parallel()
+	}
+
+	Convey("Given roles with inheritance", t, func() {
+		roles, err := NewRoles(
+			Role{Name: "viewer", Permissions: []string{"orders:read"}},
+			Role{Name: "editor", Permissions: []string{"orders:write", "orders:read"}, Inherits: []string{"viewer"}},
+			Role{Name: "admin", Permissions: []string{"users"}, Inherits: []string{"editor"}},
+			Role{Name: "billing", Permissions: []string{"invoices:*"}},
+		)
+		So(err, ShouldBeNil)
+
+		Convey("When the permissions of a role are resolved", func() {
+			permissions := roles.Permissions("admin")
+
+			Convey("Then inherited permissions should be included once", func() {
+				So(permissions, ShouldResemble, []string{"users", "orders:write", "orders:read"})
+			})
+		})
+
+		Convey("When the permissions of several roles are resolved", func() {
+			permissions := roles.Permissions("viewer", "billing", "unknown")
+
+			Convey("Then they should be merged and unknown roles ignored", func() {
+				So(permissions, ShouldResemble, []string{"orders:read", "invoices:*"})
+			})
+		})
+
+		Convey("When the roles are replaced", func() {
+			So(roles.Set(Role{Name: "viewer", Permissions: []string{"products:read"}}), ShouldBeNil)
+
+			Convey("Then the new roles should be used", func() {
+				So(roles.Permissions("viewer"), ShouldResemble, []string{"products:read"})
+				So(roles.Permissions("admin"), ShouldBeEmpty)
+			})
+		})
+
+		Convey("When invalid roles are set", func() {
+			unknown := roles.Set(Role{Name: "a", Inherits: []string{"missing"}})
+			cycle := roles.Set(
+				Role{Name: "a", Inherits: []string{"b"}},
+				Role{Name: "b", Inherits: []string{"a"}},
+			)
+
+			Convey("Then an error should be returned and the roles kept", func() {
+				So(errors.Is(unknown, ErrRoleUnknown), ShouldBeTrue)
+				So(errors.Is(cycle, ErrRoleCycle), ShouldBeTrue)
+				So(roles.Permissions("viewer"), ShouldResemble, []string{"orders:read"})
+			})
+		})
+
+		Convey("When roles not loaded from a file are reloaded", func() {
+			err := roles.Reload()
+
+			Convey("Then ErrRolesNotLoaded should be returned", func() {
+				So(err, ShouldEqual, ErrRolesNotLoaded)
+			})
+		})
+	})
+}
+
+func TestLoadRoles(t *testing.T) {
+	if !testing.Verbose() {
+		t.Parallel()
+	}
+
+	Convey("Given role files", t, func() {
+		dir, err := ioutil.TempDir("", "auth-roles")
+		So(err, ShouldBeNil)
+
+		Reset(func() {
+			os.RemoveAll(dir)
+		})
+
+		yamlPath := filepath.Join(dir, "roles.yaml")
+		So(ioutil.WriteFile(yamlPath, []byte(`
+roles:
+  viewer:
+    permissions: [orders:read]
+  admin:
+    inherits: [viewer]
+    permissions: [users]
+`), 0600), ShouldBeNil)
+
+		jsonPath := filepath.Join(dir, "roles.json")
+		So(ioutil.WriteFile(jsonPath, []byte(`{
+			"roles": {
+				"viewer": {"permissions": ["orders:read"]},
+				"admin": {"inherits": ["viewer"], "permissions": ["users"]}
+			}
+		}`), 0600), ShouldBeNil)
+
+		Convey("When they are loaded", func() {
+			fromYAML, err := LoadRoles(yamlPath)
+			So(err, ShouldBeNil)
+			fromJSON, err := LoadRoles(jsonPath)
+			So(err, ShouldBeNil)
+
+			Convey("Then the roles should be resolved", func() {
+				So(fromYAML.Permissions("admin"), ShouldResemble, []string{"users", "orders:read"})
+				So(fromJSON.Permissions("admin"), ShouldResemble, []string{"users", "orders:read"})
+			})
+		})
+
+		Convey("When a file is changed and reloaded", func() {
+			roles, err := LoadRoles(yamlPath)
+			So(err, ShouldBeNil)
+
+			So(ioutil.WriteFile(yamlPath, []byte("roles:\n  admin:\n    permissions: ['*']\n"), 0600), ShouldBeNil)
+			So(roles.Reload(), ShouldBeNil)
+
+			Convey("Then the new roles should be used", func() {
+				So(roles.Permissions("admin"), ShouldResemble, []string{"*"})
+				So(roles.Permissions("viewer"), ShouldBeEmpty)
+			})
+		})
+
+		Convey("When a file is invalid", func() {
+			roles, err := LoadRoles(yamlPath)
+			So(err, ShouldBeNil)
+
+			So(ioutil.WriteFile(yamlPath, []byte("roles:\n  admin:\n    inherits: [missing]\n"), 0600), ShouldBeNil)
+			err = roles.Reload()
+
+			Convey("Then the current roles should be kept", func() {
+				So(errors.Is(err, ErrRoleUnknown), ShouldBeTrue)
+				So(roles.Permissions("admin"), ShouldResemble, []string{"users", "orders:read"})
+			})
+		})
+
+		Convey("When a file does not exist", func() {
+			_, err := LoadRoles(filepath.Join(dir, "missing.yaml"))
+
+			Convey("Then an error should be returned", func() {
+				So(os.IsNotExist(err), ShouldBeTrue)
+			})
+		})
+	})
+}
+
+func TestAuthenticatorRoles(t *testing.T) {
+	if !testing.Verbose() {
+		t.Parallel()
+	}
+
+	Convey("Given an Authenticator with roles", t, func() {
+		roles, err := NewRoles(
+			Role{Name: "viewer", Permissions: []string{"orders:read"}},
+			Role{Name: "editor", Permissions: []string{"orders:write"}, Inherits: []string{"viewer"}},
+		)
+		So(err, ShouldBeNil)
+
+		generator := NewMockTokenGenerator()
+		auth := NewAuthenticator(generator, nil, time.Hour, time.Hour, WithRoles(roles))
+
+		user := &User{UID: "test_uid", Permissions: []string{"profile"}, Roles: []string{"editor"}}
+		token, err := auth.Generate(user)
+		So(err, ShouldBeNil)
+
+		Convey("When the token is generated", func() {
+			parsed, err := generator.Verify(token)
+			So(err, ShouldBeNil)
+
+			Convey("Then it should carry the roles and not their permissions", func() {
+				So(parsed.Claims["roles"], ShouldResemble, []interface{}{"editor"})
+				So(parsed.Claims["permissions"], ShouldResemble, []interface{}{"profile"})
+			})
+		})
+
+		Convey("When the token is validated", func() {
+			validated, err := auth.ValidateToken(token)
+			So(err, ShouldBeNil)
+
+			Convey("Then the permissions of the roles should be included", func() {
+				So(validated.Roles, ShouldResemble, []string{"editor"})
+				So(validated.Permissions, ShouldResemble, []string{"profile", "orders:write", "orders:read"})
+				So(validated.HasPermission("orders:read"), ShouldBeTrue)
+			})
+		})
+
+		Convey("When the roles change", func() {
+			So(roles.Set(Role{Name: "editor", Permissions: []string{"products:write"}}), ShouldBeNil)
+
+			validated, err := auth.ValidateToken(token)
+			So(err, ShouldBeNil)
+
+			Convey("Then existing tokens should get the new permissions", func() {
+				So(validated.Permissions, ShouldResemble, []string{"profile", "products:write"})
+			})
+		})
+
+		Convey("When the token is refreshed", func() {
+			refresh, err := auth.GenerateRefresh(user)
+			So(err, ShouldBeNil)
+
+			token, _, err := auth.Refresh(refresh)
+			So(err, ShouldBeNil)
+
+			parsed, err := generator.Verify(token)
+			So(err, ShouldBeNil)
+
+			Convey("Then the new token should not carry the expanded permissions", func() {
+				So(parsed.Claims["permissions"], ShouldResemble, []interface{}{"profile"})
+				So(parsed.Claims["roles"], ShouldResemble, []interface{}{"editor"})
+			})
+		})
+	})
+}
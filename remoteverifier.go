@@ -0,0 +1,239 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pquerna/ffjson/ffjson"
+
+	"gopkg.in/dgrijalva/jwt-go.v2"
+)
+
+// Defaults used by RemoteVerifier
+const (
+	// DefaultJWKSLifetime is how long keys are cached when the JWKS response
+	// has no Cache-Control max-age
+	DefaultJWKSLifetime = time.Hour
+
+	// DefaultJWKSRefreshInterval is the minimum time between fetches, whether
+	// triggered by the cache expiring or by an unknown kid
+	DefaultJWKSRefreshInterval = time.Minute
+
+	// DefaultJWKSTimeout is the timeout of the client used when none is given
+	DefaultJWKSTimeout = 10 * time.Second
+)
+
+type remoteKey struct {
+	key        interface{}
+	algorithms []string
+}
+
+// RemoteVerifier verifies tokens using public keys fetched from a JWKS URL
+type RemoteVerifier struct {
+	url    string
+	client *http.Client
+
+	lifetime        time.Duration
+	refreshInterval time.Duration
+
+	options *verifierOptions
+
+	mutex   sync.Mutex
+	keys    map[string]*remoteKey
+	expires time.Time
+	fetched time.Time
+	err     error
+
+	// fetching is closed when the fetch in progress completes, it is nil if
+	// there is none
+	fetching chan struct{}
+}
+
+// NewRemoteVerifier creates a RemoteVerifier, if client is nil a client with
+// DefaultJWKSTimeout is used. Tokens are accepted if their algorithm is
+// allowed by both the options and the JWK.
+func NewRemoteVerifier(url string, client *http.Client, options ...VerifierOption) *RemoteVerifier {
+	if client == nil {
+		client = &http.Client{Timeout: DefaultJWKSTimeout}
+	}
+
+	return &RemoteVerifier{
+		url:             url,
+		client:          client,
+		lifetime:        DefaultJWKSLifetime,
+		refreshInterval: DefaultJWKSRefreshInterval,
+		options:         newVerifierOptions(options),
+	}
+}
+
+// Verify verifies and parses a token string
+func (v *RemoteVerifier) Verify(str string) (*jwt.Token, error) {
+	return v.options.parse(str, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+
+		key, err := v.key(kid)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, alg := range key.algorithms {
+			if token.Header["alg"] == alg {
+				return key.key, nil
+			}
+		}
+
+		return nil, ErrAlgorithmNotAllowed
+	})
+}
+
+// key returns the key for a kid. Keys are refetched when the cache expires or
+// the kid is unknown, as the keys may have been rotated, but at most once per
+// refresh interval so the JWKS server is not flooded. Keys are kept when a
+// fetch fails.
+func (v *RemoteVerifier) key(kid string) (*remoteKey, error) {
+	v.mutex.Lock()
+	expired := v.keys == nil || time.Now().After(v.expires)
+	v.mutex.Unlock()
+
+	if expired {
+		v.refresh()
+	}
+
+	if key, ok, err := v.lookup(kid); ok || err != nil {
+		return key, err
+	}
+
+	if v.refresh() {
+		if key, ok, err := v.lookup(kid); ok || err != nil {
+			return key, err
+		}
+	}
+
+	return nil, ErrUnknownKID
+}
+
+// lookup finds a cached key, the error of the last fetch is returned if there
+// are no keys
+func (v *RemoteVerifier) lookup(kid string) (*remoteKey, bool, error) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if v.keys == nil {
+		if v.err != nil {
+			return nil, false, v.err
+		}
+		return nil, false, ErrUnknownKID
+	}
+
+	key, ok := v.keys[kid]
+	return key, ok, nil
+}
+
+// refresh fetches the keys unless they were fetched within the refresh
+// interval, it returns false if no fetch was made. The lock is not held during
+// the request, concurrent callers use the current keys or wait for the same
+// fetch if there are none.
+func (v *RemoteVerifier) refresh() bool {
+	v.mutex.Lock()
+
+	if fetching := v.fetching; fetching != nil {
+		if v.keys != nil {
+			v.mutex.Unlock()
+			return false
+		}
+
+		v.mutex.Unlock()
+		<-fetching
+		return true
+	}
+
+	now := time.Now()
+	if !v.fetched.IsZero() && now.Sub(v.fetched) < v.refreshInterval {
+		v.mutex.Unlock()
+		return false
+	}
+
+	fetching := make(chan struct{})
+	v.fetching = fetching
+	v.fetched = now
+	v.mutex.Unlock()
+
+	keys, lifetime, err := v.fetch()
+
+	v.mutex.Lock()
+	if err == nil {
+		v.keys = keys
+		v.expires = now.Add(lifetime)
+	}
+	v.err = err
+	v.fetching = nil
+	v.mutex.Unlock()
+
+	close(fetching)
+	return true
+}
+
+// fetch gets the keys and their cache lifetime from the JWKS URL
+func (v *RemoteVerifier) fetch() (map[string]*remoteKey, time.Duration, error) {
+	response, err := v.client.Get(v.url)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("JWKS request failed with status %d", response.StatusCode)
+	}
+
+	jwks := &JWKS{}
+	decoder := ffjson.NewDecoder()
+	if err := decoder.DecodeReader(response.Body, jwks); err != nil {
+		return nil, 0, err
+	}
+
+	keys := make(map[string]*remoteKey, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		key, err := jwk.PublicKey()
+		if err != nil {
+			continue
+		}
+
+		keys[jwk.KeyID] = &remoteKey{
+			key:        key,
+			algorithms: jwk.Algorithms(),
+		}
+	}
+
+	return keys, cacheLifetime(response.Header.Get("Cache-Control"), v.lifetime), nil
+}
+
+func cacheLifetime(header string, fallback time.Duration) time.Duration {
+	if header == "" {
+		return fallback
+	}
+
+	for _, directive := range strings.Split(header, ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+
+		switch {
+		case directive == "no-cache" || directive == "no-store":
+			return 0
+		case strings.HasPrefix(directive, "max-age="):
+			seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+			if err != nil || seconds < 0 {
+				return 0
+			}
+			return time.Duration(seconds) * time.Second
+		}
+	}
+
+	return fallback
+}
@@ -0,0 +1,334 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gopkg.in/dgrijalva/jwt-go.v2"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRemoteVerifier(t *testing.T) {
+	if !testing.Verbose() {
+		t.Parallel()
+	}
+
+	Convey("Given a remote verifier backed by a JWKS server", t, func() {
+		keygen := NewMockRSAKeyGen("key1")
+		method, err := SigningMethodRSA(keygen, Size256)
+		So(err, ShouldBeNil)
+		generator := NewTokenGenerator(method)
+
+		jwks, err := NewJWKSHandler(method)
+		So(err, ShouldBeNil)
+
+		var requests, failing int32
+		var hang chan struct{}
+		cacheControl := "max-age=3600"
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&requests, 1) > 1 && hang != nil {
+				<-hang
+			}
+			if atomic.LoadInt32(&failing) != 0 {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Cache-Control", cacheControl)
+			jwks.ServeHTTP(w, r)
+		}))
+
+		Reset(func() {
+			if hang != nil {
+				close(hang)
+			}
+			server.Close()
+		})
+
+		verifier := NewRemoteVerifier(server.URL, nil)
+
+		Convey("When a token signed by the server key is verified", func() {
+			token := generator.Create()
+			token.Claims["foo"] = "bar"
+			str, err := generator.Sign(token)
+			So(err, ShouldBeNil)
+
+			parsed, err := verifier.Verify(str)
+
+			Convey("Then the token should be valid", func() {
+				So(err, ShouldBeNil)
+				So(parsed.Claims["foo"], ShouldEqual, "bar")
+			})
+		})
+
+		Convey("When the algorithm is not in the verifier allow-list", func() {
+			restricted := NewRemoteVerifier(server.URL, nil, WithAlgorithms("ES256"))
+
+			str, err := generator.Sign(generator.Create())
+			So(err, ShouldBeNil)
+
+			parsed, err := restricted.Verify(str)
+
+			Convey("Then ErrAlgorithmNotAllowed should be returned", func() {
+				So(parsed, ShouldBeNil)
+				So(err, ShouldEqual, ErrAlgorithmNotAllowed)
+			})
+		})
+
+		Convey("When multiple tokens are verified within the cache lifetime", func() {
+			for i := 0; i < 3; i++ {
+				str, err := generator.Sign(generator.Create())
+				So(err, ShouldBeNil)
+
+				_, err = verifier.Verify(str)
+				So(err, ShouldBeNil)
+			}
+
+			Convey("Then the key set should only be fetched once", func() {
+				So(atomic.LoadInt32(&requests), ShouldEqual, 1)
+			})
+		})
+
+		Convey("When the key set must not be cached", func() {
+			cacheControl = "no-store"
+
+			verify := func() {
+				for i := 0; i < 3; i++ {
+					str, err := generator.Sign(generator.Create())
+					So(err, ShouldBeNil)
+
+					_, err = verifier.Verify(str)
+					So(err, ShouldBeNil)
+				}
+			}
+
+			Convey("Then the key set should only be refetched once per refresh interval", func() {
+				verify()
+				So(atomic.LoadInt32(&requests), ShouldEqual, 1)
+			})
+
+			Convey("Then the key set should be fetched every time without a refresh interval", func() {
+				verifier.refreshInterval = 0
+				verify()
+				So(atomic.LoadInt32(&requests), ShouldEqual, 3)
+			})
+		})
+
+		Convey("When the JWKS server fails after the keys expire", func() {
+			cacheControl = "max-age=0"
+
+			str, err := generator.Sign(generator.Create())
+			So(err, ShouldBeNil)
+			_, err = verifier.Verify(str)
+			So(err, ShouldBeNil)
+
+			atomic.StoreInt32(&failing, 1)
+			verifier.mutex.Lock()
+			verifier.fetched = time.Time{}
+			verifier.mutex.Unlock()
+
+			var errs []error
+			for i := 0; i < 20; i++ {
+				_, err := verifier.Verify(str)
+				errs = append(errs, err)
+			}
+
+			Convey("Then the stale keys should be used and refetching rate limited", func() {
+				for _, err := range errs {
+					So(err, ShouldBeNil)
+				}
+				So(atomic.LoadInt32(&requests), ShouldEqual, 2)
+			})
+		})
+
+		Convey("When the JWKS server hangs after the keys expire", func() {
+			cacheControl = "max-age=0"
+			verifier.refreshInterval = 0
+
+			str, err := generator.Sign(generator.Create())
+			So(err, ShouldBeNil)
+			_, err = verifier.Verify(str)
+			So(err, ShouldBeNil)
+
+			hang = make(chan struct{})
+			go verifier.Verify(str)
+			for atomic.LoadInt32(&requests) < 2 {
+				time.Sleep(time.Millisecond)
+			}
+
+			done := make(chan error, 1)
+			go func() {
+				_, err := verifier.Verify(str)
+				done <- err
+			}()
+
+			Convey("Then other verifications should use the stale keys", func() {
+				select {
+				case err := <-done:
+					So(err, ShouldBeNil)
+				case <-time.After(time.Second):
+					So("verification blocked", ShouldBeEmpty)
+				}
+			})
+		})
+
+		Convey("When the JWKS server fails before any keys are fetched", func() {
+			atomic.StoreInt32(&failing, 1)
+
+			str, err := generator.Sign(generator.Create())
+			So(err, ShouldBeNil)
+
+			_, first := verifier.Verify(str)
+			_, second := verifier.Verify(str)
+
+			Convey("Then the fetch error should be returned without refetching", func() {
+				So(first, ShouldNotBeNil)
+				So(second, ShouldNotBeNil)
+				So(atomic.LoadInt32(&requests), ShouldEqual, 1)
+			})
+		})
+
+		Convey("When a token with a new kid is verified", func() {
+			str, err := generator.Sign(generator.Create())
+			So(err, ShouldBeNil)
+			_, err = verifier.Verify(str)
+			So(err, ShouldBeNil)
+
+			keygen.Rotate("key2")
+			verifier.refreshInterval = 0
+
+			str, err = generator.Sign(generator.Create())
+			So(err, ShouldBeNil)
+			_, err = verifier.Verify(str)
+
+			Convey("Then the key set should be refetched", func() {
+				So(err, ShouldBeNil)
+				So(atomic.LoadInt32(&requests), ShouldEqual, 2)
+			})
+		})
+
+		Convey("When tokens with unknown kids are verified repeatedly", func() {
+			otherMethod, err := SigningMethodRSA(NewMockRSAKeyGen("unknown"), Size256)
+			So(err, ShouldBeNil)
+			other := NewTokenGenerator(otherMethod)
+
+			for i := 0; i < 3; i++ {
+				str, err := other.Sign(other.Create())
+				So(err, ShouldBeNil)
+
+				parsed, err := verifier.Verify(str)
+				So(parsed, ShouldBeNil)
+				So(err, ShouldNotBeNil)
+			}
+
+			Convey("Then refetching should be rate limited", func() {
+				So(atomic.LoadInt32(&requests), ShouldEqual, 1)
+			})
+		})
+
+		Convey("When a HMAC token using the public key as a secret is verified", func() {
+			secret, err := x509.MarshalPKIXPublicKey(&keygen.Key("key1").PublicKey)
+			So(err, ShouldBeNil)
+
+			token := jwt.New(jwt.SigningMethodHS256)
+			token.Header["kid"] = "key1"
+			str, err := token.SignedString(secret)
+			So(err, ShouldBeNil)
+
+			parsed, err := verifier.Verify(str)
+
+			Convey("Then an error should be returned", func() {
+				So(parsed, ShouldBeNil)
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When a token is signed with an algorithm not listed for the key", func() {
+			pssMethod, err := SigningMethodRSAPSS(keygen, Size256)
+			So(err, ShouldBeNil)
+			pss := NewTokenGenerator(pssMethod)
+			str, err := pss.Sign(pss.Create())
+			So(err, ShouldBeNil)
+
+			parsed, err := verifier.Verify(str)
+
+			Convey("Then an error should be returned", func() {
+				So(parsed, ShouldBeNil)
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When an Authenticator validates tokens using the verifier", func() {
+			signing := NewAuthenticator(generator, NewMockStorage("test_uid", "test_user", "test_pass", nil), time.Hour, time.Hour)
+			token, _, err := signing.Authenticate("test_user", "test_pass")
+			So(err, ShouldBeNil)
+
+			validating := NewVerifyingAuthenticator(verifier)
+			user, err := validating.ValidateToken(token)
+
+			Convey("Then the user should be returned", func() {
+				So(err, ShouldBeNil)
+				So(user.UID, ShouldEqual, "test_uid")
+			})
+
+			Convey("Then it should not be able to issue tokens", func() {
+				_, _, err := validating.Authenticate("test_user", "test_pass")
+				So(err, ShouldEqual, ErrCannotSign)
+			})
+		})
+	})
+
+	Convey("Given Cache-Control headers", t, func() {
+		Convey("Then the cache lifetime should be parsed", func() {
+			So(cacheLifetime("", time.Hour), ShouldEqual, time.Hour)
+			So(cacheLifetime("public, max-age=60", time.Hour), ShouldEqual, time.Minute)
+			So(cacheLifetime("no-cache", time.Hour), ShouldEqual, 0)
+			So(cacheLifetime("max-age=invalid", time.Hour), ShouldEqual, 0)
+			So(cacheLifetime("public", time.Hour), ShouldEqual, time.Hour)
+		})
+	})
+}
+
+type mockRSAKeyGen struct {
+	active string
+	keys   map[string]*rsa.PrivateKey
+}
+
+func NewMockRSAKeyGen(kid string) *mockRSAKeyGen {
+	keygen := &mockRSAKeyGen{
+		keys: make(map[string]*rsa.PrivateKey),
+	}
+	keygen.Rotate(kid)
+	return keygen
+}
+
+func (m *mockRSAKeyGen) Rotate(kid string) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	m.keys[kid] = key
+	m.active = kid
+}
+
+func (m *mockRSAKeyGen) KID() interface{} {
+	return m.active
+}
+
+func (m *mockRSAKeyGen) KIDs() []interface{} {
+	kids := make([]interface{}, 0, len(m.keys))
+	for kid := range m.keys {
+		kids = append(kids, kid)
+	}
+	return kids
+}
+
+func (m *mockRSAKeyGen) Key(kid interface{}) *rsa.PrivateKey {
+	if str, ok := kid.(string); ok {
+		return m.keys[str]
+	}
+	return nil
+}
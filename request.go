@@ -4,14 +4,47 @@ package auth
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"io/ioutil"
+	"mime"
 	"net/http"
 	"strings"
 
 	"github.com/pquerna/ffjson/ffjson"
 )
 
+// Errors returned from ParseRequest
+var (
+	ErrTokenMultipleSources = errors.New("Token was sent using more than one method")
+	ErrAuthorizationInvalid = errors.New("Authorization header is invalid")
+)
+
+// TokenSource is a set of ways a token can be sent in a request
+type TokenSource int
+
+// Token sources, they can be combined
+const (
+	// TokenSourceHeader is the Authorization: Bearer header, RFC 6750
+	// section 2.1
+	TokenSourceHeader TokenSource = 1 << iota
+
+	// TokenSourceBody is the access_token or token parameter of a form
+	// encoded body, RFC 6750 section 2.2, or the token field of a JSON body.
+	// Handler.Middleware only reads form encoded bodies.
+	TokenSourceBody
+
+	// TokenSourceQuery is the access_token or token query parameter, RFC 6750
+	// section 2.3. Tokens in URLs are easily leaked, so it is not a default.
+	TokenSourceQuery
+
+	// TokenSourceLegacyHeader is the non-standard Bearer header
+	TokenSourceLegacyHeader
+)
+
+// DefaultTokenSources are the token sources used by ParseRequest
+const DefaultTokenSources = TokenSourceHeader | TokenSourceBody | TokenSourceLegacyHeader
+
 // Request is a request
 type Request struct {
 	Token    string `json:"token"`
@@ -34,20 +67,24 @@ func reader(r *http.Request) (io.Reader, error) {
 	return rdr1, nil
 }
 
-// ParseRequest parses a request from a http.Request
+// ParseRequest parses a request from a http.Request, the token is read from
+// DefaultTokenSources
 func ParseRequest(r *http.Request) (*Request, error) {
+	return ParseRequestSources(r, DefaultTokenSources)
+}
+
+// ParseRequestSources parses a request from a http.Request, the token is only
+// read from the sources. ErrTokenMultipleSources is returned if the token was
+// sent using more than one method.
+func ParseRequestSources(r *http.Request, sources TokenSource) (*Request, error) {
+	// FormValue parses the query and body, including multipart bodies
 	request := &Request{
-		Token:    r.FormValue("token"),
 		Username: r.FormValue("username"),
 		Password: r.FormValue("password"),
 		Refresh:  r.FormValue("refresh_token"),
 	}
 	contentType := r.Header.Get("Content-Type")
 
-	if tok := r.Header.Get("Bearer"); tok != "" {
-		request.Token = tok
-	}
-
 	if strings.Contains(contentType, "json") {
 		decoder := ffjson.NewDecoder()
 
@@ -62,5 +99,114 @@ func ParseRequest(r *http.Request) (*Request, error) {
 		}
 	}
 
+	// The token from the JSON body is only used if the body is a source
+	body := []string{request.Token, r.PostForm.Get("access_token"), r.PostForm.Get("token")}
+
+	token, err := selectToken(r, sources, body)
+	if err != nil {
+		return nil, err
+	}
+	request.Token = token
+
 	return request, nil
 }
+
+// requestToken reads the token of a request to a protected resource. Unlike
+// ParseRequestSources the body is only read if it is a source and the request
+// is form encoded, JSON bodies belong to the application.
+func requestToken(r *http.Request, sources TokenSource) (string, error) {
+	var body []string
+
+	if sources&TokenSourceBody != 0 {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if mediaType == "application/x-www-form-urlencoded" {
+			// ParseForm limits the size of the body
+			if err := r.ParseForm(); err != nil {
+				return "", err
+			}
+			body = []string{r.PostForm.Get("access_token"), r.PostForm.Get("token")}
+		}
+	}
+
+	return selectToken(r, sources, body)
+}
+
+// selectToken returns the token sent using one of the sources, body holds the
+// tokens found in the body
+func selectToken(r *http.Request, sources TokenSource, body []string) (string, error) {
+	var tokens []string
+	add := func(source TokenSource, token string) {
+		if sources&source != 0 && token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+
+	header, err := bearerToken(r)
+	if err != nil && sources&TokenSourceHeader != 0 {
+		return "", err
+	}
+	add(TokenSourceHeader, header)
+
+	for _, token := range body {
+		add(TokenSourceBody, token)
+	}
+
+	query := r.URL.Query()
+	add(TokenSourceQuery, query.Get("access_token"))
+	add(TokenSourceQuery, query.Get("token"))
+
+	add(TokenSourceLegacyHeader, r.Header.Get("Bearer"))
+
+	switch len(tokens) {
+	case 0:
+		return "", nil
+	case 1:
+		return tokens[0], nil
+	}
+	return "", ErrTokenMultipleSources
+}
+
+// bearerToken returns the token of an Authorization: Bearer header, other
+// authorization schemes are ignored
+func bearerToken(r *http.Request) (string, error) {
+	authorization := r.Header.Get("Authorization")
+	if authorization == "" {
+		return "", nil
+	}
+
+	parts := strings.SplitN(authorization, " ", 2)
+	if !strings.EqualFold(parts[0], "Bearer") {
+		return "", nil
+	}
+
+	if len(parts) != 2 {
+		return "", ErrAuthorizationInvalid
+	}
+
+	token := strings.TrimLeft(parts[1], " ")
+	if !isB64Token(token) {
+		return "", ErrAuthorizationInvalid
+	}
+
+	return token, nil
+}
+
+// isB64Token checks the token has the b64token syntax of RFC 6750
+func isB64Token(token string) bool {
+	end := strings.TrimRight(token, "=")
+	if end == "" {
+		return false
+	}
+
+	for i := 0; i < len(end); i++ {
+		c := end[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.IndexByte("-._~+/", c) >= 0:
+		default:
+			return false
+		}
+	}
+
+	return true
+}
@@ -72,3 +72,166 @@ func TestParseRequest(t *testing.T) {
 		})
 	})
 }
+
+func TestParseRequestTokenSources(t *testing.T) {
+	if !testing.Verbose() {
+		t.Parallel()
+	}
+
+	form := func(values url.Values) *http.Request {
+		req, _ := http.NewRequest("POST", "/auth", strings.NewReader(values.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req
+	}
+
+	Convey("Given a request with an Authorization header", t, func() {
+		req, err := http.NewRequest("GET", "/auth", nil)
+		So(err, ShouldBeNil)
+		req.Header.Set("Authorization", "Bearer mF_9.B5f-4.1JqM")
+
+		Convey("When the request is parsed", func() {
+			parsed, err := ParseRequest(req)
+			So(err, ShouldBeNil)
+
+			Convey("Then the token should be returned", func() {
+				So(parsed.Token, ShouldEqual, "mF_9.B5f-4.1JqM")
+			})
+		})
+
+		Convey("When the scheme has a different case", func() {
+			req.Header.Set("Authorization", "bearer token==")
+			parsed, err := ParseRequest(req)
+			So(err, ShouldBeNil)
+
+			Convey("Then the token should be returned", func() {
+				So(parsed.Token, ShouldEqual, "token==")
+			})
+		})
+
+		Convey("When the header uses another scheme", func() {
+			req.Header.Set("Authorization", "Basic dXNlcjpwYXNz")
+			parsed, err := ParseRequest(req)
+			So(err, ShouldBeNil)
+
+			Convey("Then no token should be returned", func() {
+				So(parsed.Token, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When the header is invalid", func() {
+			invalid := []string{"Bearer", "Bearer ", "Bearer a b", "Bearer a=b", "Bearer ==="}
+
+			Convey("Then ErrAuthorizationInvalid should be returned", func() {
+				for _, header := range invalid {
+					req.Header.Set("Authorization", header)
+					parsed, err := ParseRequest(req)
+					So(parsed, ShouldBeNil)
+					So(err, ShouldEqual, ErrAuthorizationInvalid)
+				}
+			})
+		})
+
+		Convey("When the header is not an accepted source", func() {
+			req.Header.Set("Authorization", "Bearer")
+			parsed, err := ParseRequestSources(req, TokenSourceBody)
+			So(err, ShouldBeNil)
+
+			Convey("Then it should be ignored", func() {
+				So(parsed.Token, ShouldBeEmpty)
+			})
+		})
+	})
+
+	Convey("Given a request with a form encoded access_token", t, func() {
+		req := form(url.Values{"access_token": []string{"test_token"}})
+
+		Convey("When the request is parsed", func() {
+			parsed, err := ParseRequest(req)
+			So(err, ShouldBeNil)
+
+			Convey("Then the token should be returned", func() {
+				So(parsed.Token, ShouldEqual, "test_token")
+			})
+		})
+	})
+
+	Convey("Given a request with the token in the query", t, func() {
+		req, err := http.NewRequest("GET", "/auth?access_token=test_token", nil)
+		So(err, ShouldBeNil)
+
+		Convey("When the request is parsed with the default sources", func() {
+			parsed, err := ParseRequest(req)
+			So(err, ShouldBeNil)
+
+			Convey("Then the token should be ignored", func() {
+				So(parsed.Token, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When the request is parsed with the query as a source", func() {
+			parsed, err := ParseRequestSources(req, DefaultTokenSources|TokenSourceQuery)
+			So(err, ShouldBeNil)
+
+			Convey("Then the token should be returned", func() {
+				So(parsed.Token, ShouldEqual, "test_token")
+			})
+		})
+	})
+
+	Convey("Given a request with the legacy Bearer header", t, func() {
+		req, err := http.NewRequest("GET", "/auth", nil)
+		So(err, ShouldBeNil)
+		req.Header.Set("Bearer", "test_token")
+
+		Convey("When the request is parsed", func() {
+			parsed, err := ParseRequest(req)
+			So(err, ShouldBeNil)
+
+			Convey("Then the token should be returned", func() {
+				So(parsed.Token, ShouldEqual, "test_token")
+			})
+		})
+
+		Convey("When the request is parsed without legacy sources", func() {
+			parsed, err := ParseRequestSources(req, TokenSourceHeader)
+			So(err, ShouldBeNil)
+
+			Convey("Then the token should be ignored", func() {
+				So(parsed.Token, ShouldBeEmpty)
+			})
+		})
+	})
+
+	Convey("Given requests with the token sent more than once", t, func() {
+		header := form(url.Values{"access_token": []string{"test_token"}})
+		header.Header.Set("Authorization", "Bearer test_token")
+
+		fields := form(url.Values{"access_token": []string{"a"}, "token": []string{"b"}})
+
+		json, err := http.NewRequest("POST", "/auth?token=test_token", strings.NewReader(`{"token": "test_token"}`))
+		So(err, ShouldBeNil)
+		json.Header.Set("Content-Type", "application/json")
+
+		Convey("When the requests are parsed", func() {
+			_, headerErr := ParseRequest(header)
+			_, fieldsErr := ParseRequest(fields)
+			_, jsonErr := ParseRequestSources(json, TokenSourceBody|TokenSourceQuery)
+
+			Convey("Then ErrTokenMultipleSources should be returned", func() {
+				So(headerErr, ShouldEqual, ErrTokenMultipleSources)
+				So(fieldsErr, ShouldEqual, ErrTokenMultipleSources)
+				So(jsonErr, ShouldEqual, ErrTokenMultipleSources)
+				So(ErrorCode(jsonErr), ShouldEqual, CodeRequestInvalid)
+			})
+		})
+
+		Convey("When only one of the methods is a source", func() {
+			parsed, err := ParseRequestSources(header, TokenSourceHeader)
+			So(err, ShouldBeNil)
+
+			Convey("Then the token should be returned", func() {
+				So(parsed.Token, ShouldEqual, "test_token")
+			})
+		})
+	})
+}
@@ -2,9 +2,93 @@
 
 package auth
 
+import (
+	"errors"
+)
+
+// Error codes returned in Response, they are stable and can be used by
+// clients to decide how to handle a failure
+const (
+	CodeError                 = "error"
+	CodeRequestInvalid        = "request_invalid"
+	CodeTokenInvalid          = "token_invalid"
+	CodeTokenExpired          = "token_expired"
+	CodeTokenNotValidYet      = "token_not_valid_yet"
+	CodeTokenMalformed        = "token_malformed"
+	CodeSignatureInvalid      = "signature_invalid"
+	CodeAlgorithmNotAllowed   = "algorithm_not_allowed"
+	CodeTokenTypeInvalid      = "token_type_invalid"
+	CodeUnknownKID            = "unknown_kid"
+	CodeTokenRevoked          = "token_revoked"
+	CodeClaimMissing          = "claim_missing"
+	CodeClaimInvalid          = "claim_invalid"
+	CodeIssuerInvalid         = "issuer_invalid"
+	CodeAudienceInvalid       = "audience_invalid"
+	CodeClaimReserved         = "claim_reserved"
+	CodeRevocationUnsupported = "revocation_unsupported"
+	CodeRefreshTokenReused    = "refresh_token_reused"
+	CodeSessionsUnsupported   = "sessions_unsupported"
+	CodeTokenMissing          = "token_missing"
+	CodePermissionDenied      = "permission_denied"
+	CodeClientTokenRefused    = "client_token_refused"
+	CodeRefreshClientInvalid  = "refresh_client_invalid"
+	CodeTokenClientInvalid    = "token_client_invalid"
+)
+
+var errorCodes = []struct {
+	err  error
+	code string
+}{
+	{ErrTokenInvalid, CodeTokenInvalid},
+	{ErrTokenExpired, CodeTokenExpired},
+	{ErrTokenNotValidYet, CodeTokenNotValidYet},
+	{ErrTokenMalformed, CodeTokenMalformed},
+	{ErrSignatureInvalid, CodeSignatureInvalid},
+	{ErrAlgorithmNone, CodeAlgorithmNotAllowed},
+	{ErrAlgorithmNotAllowed, CodeAlgorithmNotAllowed},
+	{ErrTokenTypeInvalid, CodeTokenTypeInvalid},
+	{ErrUnknownKID, CodeUnknownKID},
+	{ErrTokenRevoked, CodeTokenRevoked},
+	{ErrClaimMissing, CodeClaimMissing},
+	{ErrClaimInvalid, CodeClaimInvalid},
+	{ErrIssuerInvalid, CodeIssuerInvalid},
+	{ErrAudienceInvalid, CodeAudienceInvalid},
+	{ErrClaimReserved, CodeClaimReserved},
+	{ErrRevocationUnsupported, CodeRevocationUnsupported},
+	{ErrRefreshTokenReused, CodeRefreshTokenReused},
+	{ErrSessionsUnsupported, CodeSessionsUnsupported},
+	{ErrTokenMissing, CodeTokenMissing},
+	{ErrPermissionDenied, CodePermissionDenied},
+	{ErrClientTokenRefused, CodeClientTokenRefused},
+	{ErrRefreshClientInvalid, CodeRefreshClientInvalid},
+	{ErrTokenClientInvalid, CodeTokenClientInvalid},
+	{ErrTokenMultipleSources, CodeRequestInvalid},
+	{ErrAuthorizationInvalid, CodeRequestInvalid},
+	{ErrMethodNotAllowed, CodeRequestInvalid},
+	{ErrCredentialsMissing, CodeRequestInvalid},
+	{ErrCredentialsInQuery, CodeRequestInvalid},
+}
+
+// ErrorCode returns the code for an error, CodeError is returned for errors
+// without a specific code
+func ErrorCode(err error) string {
+	for _, c := range errorCodes {
+		if errors.Is(err, c.err) {
+			return c.code
+		}
+	}
+	return CodeError
+}
+
 // Response is a login response
 type Response struct {
 	Error        string `json:"error,omitempty"`
+	Code         string `json:"code,omitempty"`
 	Token        string `json:"token,omitempty"`
 	RefreshToken string `json:"refresh_token,omitempty"`
 }
+
+func (r *Response) setError(err error) {
+	r.Error = err.Error()
+	r.Code = ErrorCode(err)
+}
@@ -36,6 +36,11 @@ func (mj *Response) MarshalJSONBuf(buf fflib.EncodingBuffer) error {
 		fflib.WriteJsonString(buf, string(mj.Error))
 		buf.WriteByte(',')
 	}
+	if len(mj.Code) != 0 {
+		buf.WriteString(`"code":`)
+		fflib.WriteJsonString(buf, string(mj.Code))
+		buf.WriteByte(',')
+	}
 	if len(mj.Token) != 0 {
 		buf.WriteString(`"token":`)
 		fflib.WriteJsonString(buf, string(mj.Token))
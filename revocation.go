@@ -0,0 +1,225 @@
+package auth
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pquerna/ffjson/ffjson"
+)
+
+// Errors returned from revocation
+var (
+	ErrRevocationUnsupported = errors.New("Token revocation is not supported")
+)
+
+// RevocationStore stores revoked tokens
+type RevocationStore interface {
+	// Revoke revokes a single token by its jti, expires is when the token
+	// would have expired and can be zero if the token does not expire
+	Revoke(jti string, expires time.Time) error
+
+	// RevokeUser revokes every token for the uid issued before a time
+	RevokeUser(uid string, before time.Time) error
+
+	// Revoked checks if a token has been revoked
+	Revoked(jti string, uid string, issued time.Time) (bool, error)
+}
+
+type revocations struct {
+	// Tokens maps jti to the time the token expires
+	Tokens map[string]time.Time `json:"tokens"`
+
+	// Users maps uid to the time tokens issued before are revoked
+	Users map[string]time.Time `json:"users"`
+}
+
+// MemoryRevocationStore is a RevocationStore held in memory, entries are
+// pruned once they can no longer match a valid token
+type MemoryRevocationStore struct {
+	ttl   time.Duration
+	mutex sync.RWMutex
+	data  revocations
+}
+
+// NewMemoryRevocationStore creates a MemoryRevocationStore, ttl is how long
+// user revocations and revocations of tokens without an exp are kept. It
+// should be at least the lifetime of the longest lived token.
+func NewMemoryRevocationStore(ttl time.Duration) *MemoryRevocationStore {
+	return &MemoryRevocationStore{
+		ttl: ttl,
+		data: revocations{
+			Tokens: make(map[string]time.Time),
+			Users:  make(map[string]time.Time),
+		},
+	}
+}
+
+// Revoke implements RevocationStore
+func (s *MemoryRevocationStore) Revoke(jti string, expires time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if expires.IsZero() {
+		expires = time.Now().Add(s.ttl)
+	}
+
+	s.data.Tokens[jti] = expires
+	s.prune(time.Now())
+
+	return nil
+}
+
+// RevokeUser implements RevocationStore
+func (s *MemoryRevocationStore) RevokeUser(uid string, before time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if current, ok := s.data.Users[uid]; !ok || before.After(current) {
+		s.data.Users[uid] = before
+	}
+	s.prune(time.Now())
+
+	return nil
+}
+
+// Revoked implements RevocationStore
+func (s *MemoryRevocationStore) Revoked(jti string, uid string, issued time.Time) (bool, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if _, ok := s.data.Tokens[jti]; ok && jti != "" {
+		return true, nil
+	}
+
+	if before, ok := s.data.Users[uid]; ok && issued.Before(before) {
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// Prune removes expired entries
+func (s *MemoryRevocationStore) Prune() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.prune(time.Now())
+}
+
+func (s *MemoryRevocationStore) prune(now time.Time) {
+	for jti, expires := range s.data.Tokens {
+		if now.After(expires) {
+			delete(s.data.Tokens, jti)
+		}
+	}
+
+	for uid, before := range s.data.Users {
+		if now.After(before.Add(s.ttl)) {
+			delete(s.data.Users, uid)
+		}
+	}
+}
+
+// FileRevocationStore is a MemoryRevocationStore that is saved to a file
+// whenever a token is revoked, so revocations survive restarts
+type FileRevocationStore struct {
+	*MemoryRevocationStore
+	path      string
+	saveMutex sync.Mutex
+}
+
+// NewFileRevocationStore creates a FileRevocationStore, revocations are
+// loaded from the file if it exists
+func NewFileRevocationStore(path string, ttl time.Duration) (*FileRevocationStore, error) {
+	store := &FileRevocationStore{
+		MemoryRevocationStore: NewMemoryRevocationStore(ttl),
+		path:                  path,
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ffjson.Unmarshal(data, &store.data); err != nil {
+		return nil, err
+	}
+
+	if store.data.Tokens == nil {
+		store.data.Tokens = make(map[string]time.Time)
+	}
+	if store.data.Users == nil {
+		store.data.Users = make(map[string]time.Time)
+	}
+
+	store.prune(time.Now())
+
+	return store, nil
+}
+
+// Revoke implements RevocationStore
+func (s *FileRevocationStore) Revoke(jti string, expires time.Time) error {
+	if err := s.MemoryRevocationStore.Revoke(jti, expires); err != nil {
+		return err
+	}
+	return s.save()
+}
+
+// RevokeUser implements RevocationStore
+func (s *FileRevocationStore) RevokeUser(uid string, before time.Time) error {
+	if err := s.MemoryRevocationStore.RevokeUser(uid, before); err != nil {
+		return err
+	}
+	return s.save()
+}
+
+// save writes the revocations to the file
+func (s *FileRevocationStore) save() error {
+	s.saveMutex.Lock()
+	defer s.saveMutex.Unlock()
+
+	s.mutex.RLock()
+	data, err := ffjson.Marshal(&s.data)
+	s.mutex.RUnlock()
+
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(s.path, data)
+}
+
+// writeFileAtomic writes the data to a temporary file in the same directory
+// and renames it over the path, so the file is never partially written
+func writeFileAtomic(path string, data []byte) error {
+	file, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
+}
@@ -0,0 +1,228 @@
+package auth
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMemoryRevocationStore(t *testing.T) {
+	if !testing.Verbose() {
+		t.Parallel()
+	}
+
+	Convey("Given a memory revocation store", t, func() {
+		store := NewMemoryRevocationStore(time.Hour)
+		now := time.Now()
+
+		Convey("When a token is revoked", func() {
+			So(store.Revoke("jti1", now.Add(time.Hour)), ShouldBeNil)
+
+			Convey("Then the token should be revoked", func() {
+				revoked, err := store.Revoked("jti1", "uid", now)
+				So(err, ShouldBeNil)
+				So(revoked, ShouldBeTrue)
+			})
+
+			Convey("Then other tokens should not be revoked", func() {
+				revoked, err := store.Revoked("jti2", "uid", now)
+				So(err, ShouldBeNil)
+				So(revoked, ShouldBeFalse)
+			})
+		})
+
+		Convey("When a user is revoked", func() {
+			So(store.RevokeUser("uid", now), ShouldBeNil)
+
+			Convey("Then tokens issued before should be revoked", func() {
+				revoked, err := store.Revoked("jti1", "uid", now.Add(-time.Minute))
+				So(err, ShouldBeNil)
+				So(revoked, ShouldBeTrue)
+			})
+
+			Convey("Then tokens issued at the time should not be revoked", func() {
+				revoked, err := store.Revoked("jti1", "uid", now)
+				So(err, ShouldBeNil)
+				So(revoked, ShouldBeFalse)
+			})
+
+			Convey("Then tokens issued after should not be revoked", func() {
+				revoked, err := store.Revoked("jti1", "uid", now.Add(time.Minute))
+				So(err, ShouldBeNil)
+				So(revoked, ShouldBeFalse)
+			})
+
+			Convey("Then tokens for other users should not be revoked", func() {
+				revoked, err := store.Revoked("jti1", "other", now.Add(-time.Minute))
+				So(err, ShouldBeNil)
+				So(revoked, ShouldBeFalse)
+			})
+
+			Convey("Then an earlier revocation should not replace it", func() {
+				So(store.RevokeUser("uid", now.Add(-time.Hour)), ShouldBeNil)
+
+				revoked, err := store.Revoked("jti1", "uid", now.Add(-time.Minute))
+				So(err, ShouldBeNil)
+				So(revoked, ShouldBeTrue)
+			})
+		})
+
+		Convey("When revocations expire", func() {
+			So(store.Revoke("jti1", now.Add(-time.Second)), ShouldBeNil)
+			So(store.RevokeUser("uid", now.Add(-2*time.Hour)), ShouldBeNil)
+			store.Prune()
+
+			Convey("Then they should be pruned", func() {
+				So(store.data.Tokens, ShouldBeEmpty)
+				So(store.data.Users, ShouldBeEmpty)
+			})
+		})
+	})
+}
+
+func TestFileRevocationStore(t *testing.T) {
+	if !testing.Verbose() {
+		t.Parallel()
+	}
+
+	Convey("Given a file revocation store", t, func() {
+		dir, err := ioutil.TempDir("", "auth-revocation")
+		So(err, ShouldBeNil)
+
+		Reset(func() {
+			os.RemoveAll(dir)
+		})
+
+		path := filepath.Join(dir, "revoked.json")
+		store, err := NewFileRevocationStore(path, time.Hour)
+		So(err, ShouldBeNil)
+
+		now := time.Now()
+
+		Convey("When tokens are revoked and the store is reloaded", func() {
+			So(store.Revoke("jti1", now.Add(time.Hour)), ShouldBeNil)
+			So(store.RevokeUser("uid", now), ShouldBeNil)
+
+			reloaded, err := NewFileRevocationStore(path, time.Hour)
+			So(err, ShouldBeNil)
+
+			Convey("Then the revocations should be loaded", func() {
+				revoked, err := reloaded.Revoked("jti1", "other", now)
+				So(err, ShouldBeNil)
+				So(revoked, ShouldBeTrue)
+
+				revoked, err = reloaded.Revoked("jti2", "uid", now.Add(-time.Minute))
+				So(err, ShouldBeNil)
+				So(revoked, ShouldBeTrue)
+			})
+
+			Convey("Then no temporary files should be left", func() {
+				infos, err := ioutil.ReadDir(dir)
+				So(err, ShouldBeNil)
+				So(infos, ShouldHaveLength, 1)
+			})
+		})
+
+		Convey("When the file is corrupt", func() {
+			So(ioutil.WriteFile(path, []byte("{"), 0600), ShouldBeNil)
+
+			_, err := NewFileRevocationStore(path, time.Hour)
+
+			Convey("Then an error should be returned", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
+
+func TestAuthenticatorRevocation(t *testing.T) {
+	if !testing.Verbose() {
+		t.Parallel()
+	}
+
+	Convey("Given an Authenticator with a revocation store", t, func() {
+		storage := NewMockStorage("test_uid", "test_user", "test_pass", nil)
+		auth := NewAuthenticator(NewMockTokenGenerator(), storage, time.Hour, time.Hour,
+			WithRevocationStore(NewMemoryRevocationStore(time.Hour)),
+		)
+
+		token, refresh, err := auth.Authenticate("test_user", "test_pass")
+		So(err, ShouldBeNil)
+
+		Convey("When a token is revoked", func() {
+			So(auth.Revoke(token), ShouldBeNil)
+
+			Convey("Then the token should not be valid", func() {
+				user, err := auth.ValidateToken(token)
+				So(user, ShouldBeNil)
+				So(err, ShouldEqual, ErrTokenRevoked)
+				So(ErrorCode(err), ShouldEqual, CodeTokenRevoked)
+			})
+
+			Convey("Then the refresh token should still be valid", func() {
+				_, err := auth.ValidateRefresh(refresh)
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When a user is revoked", func() {
+			past := time.Now().Add(-time.Second)
+			user := NewMockUser("test_uid")
+			s := &session{authTime: past}
+
+			token, err := auth.generate(context.Background(), user, s, past)
+			So(err, ShouldBeNil)
+			refresh, err := auth.generateRefresh(context.Background(), user, s, past)
+			So(err, ShouldBeNil)
+
+			So(auth.RevokeUser("test_uid"), ShouldBeNil)
+
+			Convey("Then every token issued before should not be valid", func() {
+				_, err := auth.ValidateToken(token)
+				So(err, ShouldEqual, ErrTokenRevoked)
+
+				_, err = auth.ValidateRefresh(refresh)
+				So(err, ShouldEqual, ErrTokenRevoked)
+			})
+
+			Convey("Then logging in again straight after should give valid tokens", func() {
+				token, refresh, err := auth.Authenticate("test_user", "test_pass")
+				So(err, ShouldBeNil)
+
+				_, err = auth.ValidateToken(token)
+				So(err, ShouldBeNil)
+
+				_, err = auth.ValidateRefresh(refresh)
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When an invalid token is revoked", func() {
+			err := auth.Revoke("invalid")
+
+			Convey("Then an error should be returned", func() {
+				So(err, ShouldEqual, ErrTokenMalformed)
+			})
+		})
+	})
+
+	Convey("Given an Authenticator without a revocation store", t, func() {
+		auth := NewMockAuthenticator("test_uid", "test_user", "test_pass", nil)
+
+		token, _, err := auth.Authenticate("test_user", "test_pass")
+		So(err, ShouldBeNil)
+
+		Convey("When a token is revoked", func() {
+			err := auth.Revoke(token)
+
+			Convey("Then ErrRevocationUnsupported should be returned", func() {
+				So(err, ShouldEqual, ErrRevocationUnsupported)
+			})
+		})
+	})
+}
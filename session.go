@@ -0,0 +1,291 @@
+package auth
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pquerna/ffjson/ffjson"
+)
+
+// Errors returned from sessions
+var (
+	ErrSessionNotFound     = errors.New("Session not found")
+	ErrSessionsUnsupported = errors.New("Sessions are not supported")
+	ErrSessionExists       = errors.New("Session already exists")
+)
+
+// Session is a login of a user, every token generated from the login or from
+// refreshing it carries the session ID in the sid claim
+type Session struct {
+	ID        string    `json:"id"`
+	UID       string    `json:"uid"`
+	Created   time.Time `json:"created"`
+	LastSeen  time.Time `json:"last_seen"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+}
+
+// SessionInfo describes the client a session is created for
+type SessionInfo struct {
+	UserAgent string
+	IP        string
+}
+
+// SessionInfoFromRequest returns the user agent and remote IP of a request.
+// Forwarding headers are not trusted, as any client can set them.
+func SessionInfoFromRequest(r *http.Request) SessionInfo {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	return SessionInfo{
+		UserAgent: r.UserAgent(),
+		IP:        ip,
+	}
+}
+
+// SessionStore stores sessions, a session is alive while it is in the store
+type SessionStore interface {
+	// Create stores a new session, ErrSessionExists is returned if a
+	// session with the ID already exists
+	Create(session *Session) error
+
+	// Get returns a session, ErrSessionNotFound is returned if the session
+	// does not exist, has been revoked or has expired
+	Get(id string) (*Session, error)
+
+	// Touch sets when a session was last seen
+	Touch(id string, seen time.Time) error
+
+	// List returns the sessions of a user, oldest first
+	List(uid string) ([]*Session, error)
+
+	// Revoke removes a session
+	Revoke(id string) error
+
+	// RevokeUser removes every session of a user
+	RevokeUser(uid string) error
+}
+
+// MemorySessionStore is a SessionStore held in memory, sessions are pruned
+// once they have not been seen for the ttl
+type MemorySessionStore struct {
+	ttl      time.Duration
+	mutex    sync.RWMutex
+	sessions map[string]*Session
+}
+
+// NewMemorySessionStore creates a MemorySessionStore, ttl should be at least
+// the refresh token lifetime
+func NewMemorySessionStore(ttl time.Duration) *MemorySessionStore {
+	return &MemorySessionStore{
+		ttl:      ttl,
+		sessions: make(map[string]*Session),
+	}
+}
+
+// Create implements SessionStore
+func (s *MemorySessionStore) Create(session *Session) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.prune(time.Now())
+
+	if _, ok := s.sessions[session.ID]; ok {
+		return ErrSessionExists
+	}
+
+	c := *session
+	s.sessions[session.ID] = &c
+
+	return nil
+}
+
+// Get implements SessionStore
+func (s *MemorySessionStore) Get(id string) (*Session, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	session, ok := s.sessions[id]
+	if !ok || s.expired(session, time.Now()) {
+		return nil, ErrSessionNotFound
+	}
+
+	c := *session
+	return &c, nil
+}
+
+// Touch implements SessionStore
+func (s *MemorySessionStore) Touch(id string, seen time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok || s.expired(session, time.Now()) {
+		return ErrSessionNotFound
+	}
+
+	if seen.After(session.LastSeen) {
+		session.LastSeen = seen
+	}
+
+	return nil
+}
+
+// List implements SessionStore
+func (s *MemorySessionStore) List(uid string) ([]*Session, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	now := time.Now()
+	sessions := []*Session{}
+
+	for _, session := range s.sessions {
+		if session.UID == uid && !s.expired(session, now) {
+			c := *session
+			sessions = append(sessions, &c)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Created.Before(sessions[j].Created)
+	})
+
+	return sessions, nil
+}
+
+// Revoke implements SessionStore
+func (s *MemorySessionStore) Revoke(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.sessions, id)
+
+	return nil
+}
+
+// RevokeUser implements SessionStore
+func (s *MemorySessionStore) RevokeUser(uid string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for id, session := range s.sessions {
+		if session.UID == uid {
+			delete(s.sessions, id)
+		}
+	}
+
+	return nil
+}
+
+// Prune removes expired sessions
+func (s *MemorySessionStore) Prune() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.prune(time.Now())
+}
+
+func (s *MemorySessionStore) expired(session *Session, now time.Time) bool {
+	return now.After(session.LastSeen.Add(s.ttl))
+}
+
+func (s *MemorySessionStore) prune(now time.Time) {
+	for id, session := range s.sessions {
+		if s.expired(session, now) {
+			delete(s.sessions, id)
+		}
+	}
+}
+
+// FileSessionStore is a MemorySessionStore that is saved to a file whenever
+// it changes, so sessions survive restarts
+type FileSessionStore struct {
+	*MemorySessionStore
+	path      string
+	saveMutex sync.Mutex
+}
+
+// NewFileSessionStore creates a FileSessionStore, sessions are loaded from the
+// file if it exists
+func NewFileSessionStore(path string, ttl time.Duration) (*FileSessionStore, error) {
+	store := &FileSessionStore{
+		MemorySessionStore: NewMemorySessionStore(ttl),
+		path:               path,
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ffjson.Unmarshal(data, &store.sessions); err != nil {
+		return nil, err
+	}
+
+	if store.sessions == nil {
+		store.sessions = make(map[string]*Session)
+	}
+
+	store.prune(time.Now())
+
+	return store, nil
+}
+
+// Create implements SessionStore
+func (s *FileSessionStore) Create(session *Session) error {
+	if err := s.MemorySessionStore.Create(session); err != nil {
+		return err
+	}
+	return s.save()
+}
+
+// Touch implements SessionStore
+func (s *FileSessionStore) Touch(id string, seen time.Time) error {
+	if err := s.MemorySessionStore.Touch(id, seen); err != nil {
+		return err
+	}
+	return s.save()
+}
+
+// Revoke implements SessionStore
+func (s *FileSessionStore) Revoke(id string) error {
+	if err := s.MemorySessionStore.Revoke(id); err != nil {
+		return err
+	}
+	return s.save()
+}
+
+// RevokeUser implements SessionStore
+func (s *FileSessionStore) RevokeUser(uid string) error {
+	if err := s.MemorySessionStore.RevokeUser(uid); err != nil {
+		return err
+	}
+	return s.save()
+}
+
+// save writes the sessions to the file
+func (s *FileSessionStore) save() error {
+	s.saveMutex.Lock()
+	defer s.saveMutex.Unlock()
+
+	s.mutex.RLock()
+	data, err := ffjson.Marshal(s.sessions)
+	s.mutex.RUnlock()
+
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(s.path, data)
+}
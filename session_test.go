@@ -0,0 +1,301 @@
+package auth
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMemorySessionStore(t *testing.T) {
+	if !testing.Verbose() {
+		t.Parallel()
+	}
+
+	Convey("Given a memory session store", t, func() {
+		store := NewMemorySessionStore(time.Hour)
+		now := time.Now()
+
+		So(store.Create(&Session{ID: "sid1", UID: "uid", Created: now, LastSeen: now}), ShouldBeNil)
+		So(store.Create(&Session{ID: "sid2", UID: "uid", Created: now.Add(time.Second), LastSeen: now}), ShouldBeNil)
+		So(store.Create(&Session{ID: "sid3", UID: "other", Created: now, LastSeen: now}), ShouldBeNil)
+
+		Convey("When a session is created twice", func() {
+			err := store.Create(&Session{ID: "sid1", UID: "uid", LastSeen: now})
+
+			Convey("Then ErrSessionExists should be returned", func() {
+				So(err, ShouldEqual, ErrSessionExists)
+			})
+		})
+
+		Convey("When the sessions of a user are listed", func() {
+			sessions, err := store.List("uid")
+			So(err, ShouldBeNil)
+
+			Convey("Then they should be returned oldest first", func() {
+				So(sessions, ShouldHaveLength, 2)
+				So(sessions[0].ID, ShouldEqual, "sid1")
+				So(sessions[1].ID, ShouldEqual, "sid2")
+			})
+		})
+
+		Convey("When a session is touched", func() {
+			So(store.Touch("sid1", now.Add(time.Minute)), ShouldBeNil)
+
+			Convey("Then the last seen time should be updated", func() {
+				session, err := store.Get("sid1")
+				So(err, ShouldBeNil)
+				So(session.LastSeen, ShouldResemble, now.Add(time.Minute))
+			})
+		})
+
+		Convey("When a session is revoked", func() {
+			So(store.Revoke("sid1"), ShouldBeNil)
+
+			Convey("Then it should not be found", func() {
+				session, err := store.Get("sid1")
+				So(session, ShouldBeNil)
+				So(err, ShouldEqual, ErrSessionNotFound)
+				So(store.Touch("sid1", now), ShouldEqual, ErrSessionNotFound)
+			})
+
+			Convey("Then other sessions should be alive", func() {
+				_, err := store.Get("sid2")
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When the sessions of a user are revoked", func() {
+			So(store.RevokeUser("uid"), ShouldBeNil)
+
+			Convey("Then none should be listed", func() {
+				sessions, err := store.List("uid")
+				So(err, ShouldBeNil)
+				So(sessions, ShouldBeEmpty)
+			})
+
+			Convey("Then sessions of other users should be alive", func() {
+				_, err := store.Get("sid3")
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When a session has not been seen for the ttl", func() {
+			So(store.Create(&Session{ID: "old", UID: "uid", LastSeen: now.Add(-2 * time.Hour)}), ShouldBeNil)
+
+			Convey("Then it should not be found", func() {
+				_, err := store.Get("old")
+				So(err, ShouldEqual, ErrSessionNotFound)
+			})
+
+			Convey("Then it should be pruned", func() {
+				store.Prune()
+				So(store.sessions, ShouldNotContainKey, "old")
+			})
+		})
+	})
+}
+
+func TestFileSessionStore(t *testing.T) {
+	if !testing.Verbose() {
+		t.Parallel()
+	}
+
+	Convey("Given a file session store", t, func() {
+		dir, err := ioutil.TempDir("", "auth-session")
+		So(err, ShouldBeNil)
+
+		Reset(func() {
+			os.RemoveAll(dir)
+		})
+
+		path := filepath.Join(dir, "sessions.json")
+		store, err := NewFileSessionStore(path, time.Hour)
+		So(err, ShouldBeNil)
+
+		now := time.Now()
+
+		Convey("When sessions are changed and the store is reloaded", func() {
+			So(store.Create(&Session{ID: "sid1", UID: "uid", LastSeen: now, UserAgent: "agent", IP: "127.0.0.1"}), ShouldBeNil)
+			So(store.Create(&Session{ID: "sid2", UID: "uid", LastSeen: now}), ShouldBeNil)
+			So(store.Revoke("sid2"), ShouldBeNil)
+
+			reloaded, err := NewFileSessionStore(path, time.Hour)
+			So(err, ShouldBeNil)
+
+			Convey("Then the sessions should be loaded", func() {
+				session, err := reloaded.Get("sid1")
+				So(err, ShouldBeNil)
+				So(session.UserAgent, ShouldEqual, "agent")
+				So(session.IP, ShouldEqual, "127.0.0.1")
+
+				_, err = reloaded.Get("sid2")
+				So(err, ShouldEqual, ErrSessionNotFound)
+			})
+
+			Convey("Then no temporary files should be left", func() {
+				infos, err := ioutil.ReadDir(dir)
+				So(err, ShouldBeNil)
+				So(infos, ShouldHaveLength, 1)
+			})
+		})
+
+		Convey("When the file is corrupt", func() {
+			So(ioutil.WriteFile(path, []byte("{"), 0600), ShouldBeNil)
+
+			_, err := NewFileSessionStore(path, time.Hour)
+
+			Convey("Then an error should be returned", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
+
+func TestAuthenticatorSessions(t *testing.T) {
+	if !testing.Verbose() {
+		t.Parallel()
+	}
+
+	Convey("Given an Authenticator with a session store", t, func() {
+		generator := NewMockTokenGenerator()
+		storage := NewMockStorage("test_uid", "test_user", "test_pass", nil)
+		auth := NewAuthenticator(generator, storage, time.Hour, time.Hour,
+			WithSessionStore(NewMemorySessionStore(time.Hour)),
+		)
+
+		info := SessionInfo{UserAgent: "agent", IP: "127.0.0.1"}
+		token1, refresh1, err := auth.AuthenticateSession("test_user", "test_pass", info)
+		So(err, ShouldBeNil)
+		token2, _, err := auth.Authenticate("test_user", "test_pass")
+		So(err, ShouldBeNil)
+
+		Convey("When the sessions of the user are listed", func() {
+			sessions, err := auth.Sessions("test_uid")
+			So(err, ShouldBeNil)
+
+			Convey("Then a session should exist for each login", func() {
+				So(sessions, ShouldHaveLength, 2)
+				So(sessions[0].UID, ShouldEqual, "test_uid")
+				So(sessions[0].UserAgent, ShouldEqual, "agent")
+				So(sessions[0].IP, ShouldEqual, "127.0.0.1")
+			})
+
+			Convey("Then the tokens should carry the session ID", func() {
+				parsed, err := generator.Verify(token1)
+				So(err, ShouldBeNil)
+				claims, err := ParseClaims(parsed)
+				So(err, ShouldBeNil)
+				So(claims.SessionID, ShouldEqual, sessions[0].ID)
+			})
+		})
+
+		Convey("When a session is revoked", func() {
+			sessions, err := auth.Sessions("test_uid")
+			So(err, ShouldBeNil)
+			So(auth.RevokeSession(sessions[0].ID), ShouldBeNil)
+
+			Convey("Then its tokens should not be valid", func() {
+				user, err := auth.ValidateToken(token1)
+				So(user, ShouldBeNil)
+				So(err, ShouldEqual, ErrTokenRevoked)
+
+				_, _, err = auth.Refresh(refresh1)
+				So(err, ShouldEqual, ErrTokenRevoked)
+			})
+
+			Convey("Then tokens of other sessions should be valid", func() {
+				_, err := auth.ValidateToken(token2)
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When every session of the user is revoked", func() {
+			So(auth.RevokeSessions("test_uid"), ShouldBeNil)
+
+			Convey("Then no tokens should be valid", func() {
+				_, err := auth.ValidateToken(token1)
+				So(err, ShouldEqual, ErrTokenRevoked)
+				_, err = auth.ValidateToken(token2)
+				So(err, ShouldEqual, ErrTokenRevoked)
+			})
+		})
+
+		Convey("When a session is refreshed", func() {
+			before, err := auth.Sessions("test_uid")
+			So(err, ShouldBeNil)
+
+			time.Sleep(10 * time.Millisecond)
+			token, _, err := auth.Refresh(refresh1)
+			So(err, ShouldBeNil)
+
+			Convey("Then the new token should be in the same session", func() {
+				parsed, err := generator.Verify(token)
+				So(err, ShouldBeNil)
+				claims, err := ParseClaims(parsed)
+				So(err, ShouldBeNil)
+				So(claims.SessionID, ShouldEqual, before[0].ID)
+			})
+
+			Convey("Then the session should have been seen", func() {
+				after, err := auth.Sessions("test_uid")
+				So(err, ShouldBeNil)
+				So(after[0].LastSeen.After(before[0].LastSeen), ShouldBeTrue)
+			})
+		})
+
+		Convey("When a token is generated without a session", func() {
+			token, err := auth.Generate(&User{UID: "test_uid"})
+			So(err, ShouldBeNil)
+
+			Convey("Then it should be valid", func() {
+				_, err := auth.ValidateToken(token)
+				So(err, ShouldBeNil)
+			})
+		})
+	})
+
+	Convey("Given an Authenticator without a session store", t, func() {
+		auth := NewMockAuthenticator("test_uid", "test_user", "test_pass", nil)
+
+		Convey("When sessions are used", func() {
+			_, err1 := auth.Sessions("test_uid")
+			err2 := auth.RevokeSession("sid")
+			err3 := auth.RevokeSessions("test_uid")
+
+			Convey("Then ErrSessionsUnsupported should be returned", func() {
+				So(err1, ShouldEqual, ErrSessionsUnsupported)
+				So(err2, ShouldEqual, ErrSessionsUnsupported)
+				So(err3, ShouldEqual, ErrSessionsUnsupported)
+				So(ErrorCode(err1), ShouldEqual, CodeSessionsUnsupported)
+			})
+		})
+	})
+}
+
+func TestSessionInfoFromRequest(t *testing.T) {
+	if !testing.Verbose() {
+		t.Parallel()
+	}
+
+	Convey("Given a request", t, func() {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(""))
+		r.RemoteAddr = "192.0.2.1:1234"
+		r.Header.Set("User-Agent", "agent")
+		r.Header.Set("X-Forwarded-For", "198.51.100.1")
+
+		Convey("When the session info is read", func() {
+			info := SessionInfoFromRequest(r)
+
+			Convey("Then the user agent and remote IP should be returned", func() {
+				So(info.UserAgent, ShouldEqual, "agent")
+				So(info.IP, ShouldEqual, "192.0.2.1")
+			})
+		})
+	})
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"context"
+	"errors"
+
+	"gopkg.in/dgrijalva/jwt-go.v2"
+)
+
+// Errors returned from Signer
+var (
+	ErrSignerKeyChanged = errors.New("Signer key is no longer active")
+)
+
+// Signer signs tokens with a private key held outside of the process, such as
+// in a HSM or KMS
+type Signer interface {
+	// KID returns the id of the key used by Sign
+	KID() interface{}
+
+	// PublicKey returns the public key for a kid
+	PublicKey(kid interface{}) interface{}
+
+	// Method returns the signing method of the key
+	Method() jwt.SigningMethod
+
+	// Sign signs the JWS signing input and returns the raw signature, ECDSA
+	// signatures must use the JWS r || s encoding. Only the active key signs,
+	// if the kid in the header is no longer active ErrSignerKeyChanged is
+	// returned and KID returns the new active kid.
+	Sign(ctx context.Context, signingInput []byte) ([]byte, error)
+}
+
+type signingMethodSigner struct {
+	signer Signer
+}
+
+// SigningMethodSigner implements SigningMethod using the public keys of a
+// Signer, it has no private keys so can only be used for verification or to
+// publish a JWKS
+func SigningMethodSigner(signer Signer) (SigningMethod, error) {
+	if signer.Method() == nil {
+		return nil, ErrAlgorithmNotSupported
+	}
+
+	return &signingMethodSigner{
+		signer: signer,
+	}, nil
+}
+
+func (s *signingMethodSigner) KID() interface{} {
+	return s.signer.KID()
+}
+
+func (s *signingMethodSigner) KIDs() []interface{} {
+	if set, ok := s.signer.(KeySet); ok {
+		return set.KIDs()
+	}
+	return []interface{}{s.signer.KID()}
+}
+
+func (s *signingMethodSigner) PublicKey(kid interface{}) interface{} {
+	return s.signer.PublicKey(kid)
+}
+
+func (s *signingMethodSigner) PrivateKey(kid interface{}) interface{} {
+	return nil
+}
+
+func (s *signingMethodSigner) Method() jwt.SigningMethod {
+	return s.signer.Method()
+}
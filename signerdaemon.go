@@ -0,0 +1,395 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pquerna/ffjson/ffjson"
+
+	"gopkg.in/dgrijalva/jwt-go.v2"
+)
+
+// Errors returned from the signing daemon
+var (
+	ErrSignerClosed = errors.New("Signing daemon is closed")
+)
+
+const (
+	signerMaxMessage = 1 << 20
+	signerTimeout    = 10 * time.Second
+
+	// signerMissInterval is the minimum time between key lookups after one
+	// finds no key
+	signerMissInterval = time.Minute
+)
+
+type signerRequest struct {
+	Op    string      `json:"op"`
+	KID   interface{} `json:"kid,omitempty"`
+	Input []byte      `json:"input,omitempty"`
+}
+
+type signerResponse struct {
+	Error     string      `json:"error,omitempty"`
+	KID       interface{} `json:"kid,omitempty"`
+	Algorithm string      `json:"alg,omitempty"`
+	Signature []byte      `json:"signature,omitempty"`
+	Key       *JWK        `json:"key,omitempty"`
+}
+
+// SigningDaemon serves signing requests for a SigningMethod over a unix
+// socket, it is a local stand in for a signing service such as a HSM or KMS
+type SigningDaemon struct {
+	method SigningMethod
+
+	mutex    sync.Mutex
+	listener net.Listener
+	closed   bool
+}
+
+// NewSigningDaemon creates a SigningDaemon
+func NewSigningDaemon(method SigningMethod) *SigningDaemon {
+	return &SigningDaemon{
+		method: method,
+	}
+}
+
+// ListenAndServe listens on the unix socket path and serves requests
+func (d *SigningDaemon) ListenAndServe(path string) error {
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	return d.Serve(listener)
+}
+
+// Serve serves requests from the listener until Close is called
+func (d *SigningDaemon) Serve(listener net.Listener) error {
+	d.mutex.Lock()
+	if d.closed {
+		d.mutex.Unlock()
+		listener.Close()
+		return ErrSignerClosed
+	}
+	d.listener = listener
+	d.mutex.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			d.mutex.Lock()
+			closed := d.closed
+			d.mutex.Unlock()
+
+			if closed {
+				return ErrSignerClosed
+			}
+			return err
+		}
+
+		go d.handle(conn)
+	}
+}
+
+// Close stops serving requests
+func (d *SigningDaemon) Close() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.closed = true
+	if d.listener != nil {
+		return d.listener.Close()
+	}
+	return nil
+}
+
+func (d *SigningDaemon) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(signerTimeout))
+
+	response := &signerResponse{}
+
+	defer func() {
+		encoder := ffjson.NewEncoder(conn)
+		encoder.Encode(response)
+	}()
+
+	request := &signerRequest{}
+	decoder := ffjson.NewDecoder()
+	if err := decoder.DecodeReader(io.LimitReader(conn, signerMaxMessage), request); err != nil {
+		response.Error = err.Error()
+		return
+	}
+
+	switch request.Op {
+	case "info":
+		response.KID = d.method.KID()
+		response.Algorithm = d.method.Method().Alg()
+	case "key":
+		key := d.method.PublicKey(request.KID)
+		if key == nil {
+			response.Error = ErrKeyNotFound.Error()
+			return
+		}
+
+		jwk, err := NewJWK(request.KID, d.method.Method().Alg(), key)
+		if err != nil {
+			response.Error = err.Error()
+			return
+		}
+		response.Key = jwk
+	case "sign":
+		// Only the active key signs, the kid in the header must name it
+		kid := d.method.KID()
+		if fmt.Sprint(signingInputKID(request.Input)) != fmt.Sprint(kid) {
+			response.Error = ErrSignerKeyChanged.Error()
+			response.KID = kid
+			response.Algorithm = d.method.Method().Alg()
+			return
+		}
+
+		key := d.method.PrivateKey(kid)
+		if key == nil {
+			response.Error = ErrKeyNotFound.Error()
+			return
+		}
+
+		signature, err := d.method.Method().Sign(string(request.Input), key)
+		if err != nil {
+			response.Error = err.Error()
+			return
+		}
+
+		raw, err := jwt.DecodeSegment(signature)
+		if err != nil {
+			response.Error = err.Error()
+			return
+		}
+
+		response.KID = kid
+		response.Signature = raw
+	default:
+		response.Error = fmt.Sprintf("Unknown operation %q", request.Op)
+	}
+}
+
+// signingInputKID returns the kid in the header of a JWS signing input
+func signingInputKID(input []byte) interface{} {
+	header := input
+	if i := bytes.IndexByte(input, '.'); i >= 0 {
+		header = input[:i]
+	}
+
+	data, err := jwt.DecodeSegment(string(header))
+	if err != nil {
+		return nil
+	}
+
+	var fields map[string]interface{}
+	if err := ffjson.Unmarshal(data, &fields); err != nil {
+		return nil
+	}
+	return fields["kid"]
+}
+
+// SocketSigner implements Signer using a SigningDaemon listening on a unix
+// socket
+type SocketSigner struct {
+	path string
+
+	mutex  sync.RWMutex
+	kid    interface{}
+	method jwt.SigningMethod
+	keys   map[string]interface{}
+
+	// missed is when a key lookup last found no key, lookups are rate limited
+	// so tokens with garbage kids can not flood the daemon
+	missed       time.Time
+	missInterval time.Duration
+}
+
+// NewSocketSigner connects to a SigningDaemon and fetches the active kid, the
+// kid is updated whenever the daemon refuses to sign because it has rotated
+// its keys
+func NewSocketSigner(ctx context.Context, path string) (*SocketSigner, error) {
+	signer := &SocketSigner{
+		path:         path,
+		keys:         make(map[string]interface{}),
+		missInterval: signerMissInterval,
+	}
+
+	if err := signer.Refresh(ctx); err != nil {
+		return nil, err
+	}
+
+	return signer, nil
+}
+
+// Refresh fetches the active kid from the daemon. Sign updates the kid
+// itself, calling Refresh after the daemon rotates its keys saves a round trip
+// and allows key lookups again straight away.
+func (s *SocketSigner) Refresh(ctx context.Context) error {
+	response, err := s.call(ctx, &signerRequest{Op: "info"})
+	if err != nil {
+		return err
+	}
+
+	return s.setActive(response)
+}
+
+// setActive sets the active kid and algorithm reported by the daemon
+func (s *SocketSigner) setActive(response *signerResponse) error {
+	method := jwt.GetSigningMethod(response.Algorithm)
+	if method == nil {
+		return ErrAlgorithmNotSupported
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.kid = response.KID
+	s.method = method
+	s.missed = time.Time{}
+
+	return nil
+}
+
+// KID implements Signer
+func (s *SocketSigner) KID() interface{} {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.kid
+}
+
+// Method implements Signer
+func (s *SocketSigner) Method() jwt.SigningMethod {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.method
+}
+
+// PublicKey implements Signer, keys are fetched from the daemon once and
+// cached. After a lookup finds no key, unknown kids are not looked up again
+// until the miss interval passes or Refresh is called.
+func (s *SocketSigner) PublicKey(kid interface{}) interface{} {
+	id := fmt.Sprint(kid)
+
+	s.mutex.RLock()
+	key, ok := s.keys[id]
+	missed := !s.missed.IsZero() && time.Since(s.missed) < s.missInterval
+	s.mutex.RUnlock()
+
+	if ok {
+		return key
+	}
+	if missed {
+		return nil
+	}
+
+	key = s.lookup(kid)
+
+	s.mutex.Lock()
+	if key != nil {
+		s.keys[id] = key
+	} else {
+		s.missed = time.Now()
+	}
+	s.mutex.Unlock()
+
+	return key
+}
+
+// lookup fetches the public key of a kid from the daemon
+func (s *SocketSigner) lookup(kid interface{}) interface{} {
+	ctx, cancel := context.WithTimeout(context.Background(), signerTimeout)
+	defer cancel()
+
+	response, err := s.call(ctx, &signerRequest{Op: "key", KID: kid})
+	if err != nil || response.Key == nil {
+		return nil
+	}
+
+	key, err := response.Key.PublicKey()
+	if err != nil {
+		return nil
+	}
+	return key
+}
+
+// Sign implements Signer, if the daemon has rotated its keys the new active
+// kid it reports is used from then on
+func (s *SocketSigner) Sign(ctx context.Context, signingInput []byte) ([]byte, error) {
+	response, err := s.call(ctx, &signerRequest{
+		Op:    "sign",
+		Input: signingInput,
+	})
+	if err != nil && response != nil && response.Error == ErrSignerKeyChanged.Error() {
+		if err := s.setActive(response); err != nil {
+			return nil, err
+		}
+		return nil, ErrSignerKeyChanged
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return response.Signature, nil
+}
+
+func (s *SocketSigner) call(ctx context.Context, request *signerRequest) (*signerResponse, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", s.path)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// Unblock reads and writes if the context is cancelled mid request
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	encoder := ffjson.NewEncoder(conn)
+	if err := encoder.Encode(request); err != nil {
+		return nil, err
+	}
+
+	// The daemon reads until the write side is closed
+	if unix, ok := conn.(*net.UnixConn); ok {
+		unix.CloseWrite()
+	}
+
+	response := &signerResponse{}
+	decoder := ffjson.NewDecoder()
+	if err := decoder.DecodeReader(io.LimitReader(conn, signerMaxMessage), response); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+
+	// The response is returned with errors as it can carry the active kid
+	if response.Error != "" {
+		return response, errors.New(response.Error)
+	}
+
+	return response, nil
+}
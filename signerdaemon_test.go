@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"context"
+	"crypto/elliptic"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSigningDaemon(t *testing.T) {
+	if !testing.Verbose() {
+		t.Parallel()
+	}
+
+	Convey("Given a signing daemon listening on a unix socket", t, func() {
+		dir, err := ioutil.TempDir("", "auth-signer")
+		So(err, ShouldBeNil)
+
+		path := filepath.Join(dir, "signer.sock")
+
+		keyring := NewECDSAKeyring(elliptic.P256(), KeyringOptions{VerifyPeriod: time.Hour})
+		So(keyring.Rotate(), ShouldBeNil)
+
+		method, err := SigningMethodECDSA(keyring, Size256)
+		So(err, ShouldBeNil)
+		daemon := NewSigningDaemon(method)
+
+		go daemon.ListenAndServe(path)
+
+		Reset(func() {
+			daemon.Close()
+			os.RemoveAll(dir)
+		})
+
+		var signer *SocketSigner
+		for i := 0; i < 100; i++ {
+			signer, err = NewSocketSigner(context.Background(), path)
+			if err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		So(err, ShouldBeNil)
+
+		generator, err := NewSignerTokenGenerator(signer)
+		So(err, ShouldBeNil)
+
+		Convey("When a token is signed by the daemon", func() {
+			token := generator.Create()
+			token.Claims["foo"] = "bar"
+
+			str, err := generator.Sign(token)
+			So(err, ShouldBeNil)
+
+			Convey("Then the token should use the daemon key", func() {
+				So(token.Header["kid"], ShouldEqual, keyring.KID())
+				So(token.Header["alg"], ShouldEqual, "ES256")
+			})
+
+			Convey("Then the token should verify using the daemon public key", func() {
+				parsed, err := generator.Verify(str)
+				So(err, ShouldBeNil)
+				So(parsed.Claims["foo"], ShouldEqual, "bar")
+			})
+
+			Convey("Then the token should verify using the original key", func() {
+				_, err := NewTokenGenerator(method).Verify(str)
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When the daemon keys are rotated", func() {
+			before := signer.KID()
+			So(keyring.Rotate(), ShouldBeNil)
+			So(signer.Refresh(context.Background()), ShouldBeNil)
+
+			Convey("Then the new kid should be used", func() {
+				So(signer.KID(), ShouldNotEqual, before)
+				So(signer.KID(), ShouldEqual, keyring.KID())
+
+				str, err := generator.Sign(generator.Create())
+				So(err, ShouldBeNil)
+
+				_, err = generator.Verify(str)
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When the daemon keys are rotated and the old key retired without a refresh", func() {
+			before := signer.KID()
+			So(keyring.Rotate(), ShouldBeNil)
+			So(keyring.SetState(before.(string), KeyRetired), ShouldBeNil)
+
+			token := generator.Create()
+			str, err := generator.Sign(token)
+			So(err, ShouldBeNil)
+
+			Convey("Then the token should be signed with the new active key", func() {
+				So(token.Header["kid"], ShouldEqual, keyring.KID())
+				So(signer.KID(), ShouldEqual, keyring.KID())
+
+				_, err := generator.Verify(str)
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When signing input naming a key that is no longer active", func() {
+			token := generator.Create()
+			token.Header["kid"] = signer.KID()
+			input, err := token.SigningString()
+			So(err, ShouldBeNil)
+
+			So(keyring.Rotate(), ShouldBeNil)
+
+			_, err = signer.Sign(context.Background(), []byte(input))
+
+			Convey("Then ErrSignerKeyChanged should be returned", func() {
+				So(err, ShouldEqual, ErrSignerKeyChanged)
+			})
+
+			Convey("Then the signer should use the new active kid", func() {
+				So(signer.KID(), ShouldEqual, keyring.KID())
+			})
+		})
+
+		Convey("When the keys are published", func() {
+			method, err := SigningMethodSigner(signer)
+			So(err, ShouldBeNil)
+
+			jwks, err := NewJWKS(method)
+			So(err, ShouldBeNil)
+
+			Convey("Then the active key should be published", func() {
+				So(jwks.Keys, ShouldHaveLength, 1)
+				So(jwks.Keys[0].KeyID, ShouldEqual, keyring.KID())
+			})
+		})
+
+		Convey("When signing with a cancelled context", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			_, err := generator.SignContext(ctx, generator.Create())
+
+			Convey("Then an error should be returned", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When the daemon is closed", func() {
+			So(daemon.Close(), ShouldBeNil)
+
+			_, err := generator.Sign(generator.Create())
+
+			Convey("Then signing should fail", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When the signer requests an unknown key", func() {
+			key := signer.PublicKey("unknown")
+
+			Convey("Then no key should be returned", func() {
+				So(key, ShouldBeNil)
+			})
+
+			Convey("Then other unknown kids should not be looked up", func() {
+				So(keyring.Rotate(), ShouldBeNil)
+				So(signer.PublicKey(keyring.KID()), ShouldBeNil)
+			})
+
+			Convey("Then a new key should be found after a refresh", func() {
+				So(keyring.Rotate(), ShouldBeNil)
+				So(signer.Refresh(context.Background()), ShouldBeNil)
+
+				So(signer.PublicKey(keyring.KID()), ShouldNotBeNil)
+			})
+		})
+	})
+}
@@ -2,15 +2,36 @@ package auth
 
 import (
 	"crypto/ecdsa"
-	"crypto/rand"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
 	"crypto/rsa"
 	"crypto/sha1"
-	"encoding/base64"
+	"crypto/sha256"
 	"encoding/binary"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
 
 	"gopkg.in/dgrijalva/jwt-go.v2"
 )
 
+// Errors returned from signing methods
+var (
+	ErrSignatureInvalid     = errors.New("Signature is invalid")
+	ErrSizeInvalid          = errors.New("Size is not valid for the key")
+	ErrKeyTooSmall          = errors.New("Key is too small")
+	ErrKeyAlgorithmMismatch = errors.New("Key can not be used with the algorithm")
+	ErrSecretRequired       = errors.New("Active secret is required")
+)
+
+// Minimum key sizes accepted by signing methods
+const (
+	MinRSAKeySize    = 2048
+	MinHMACSecretLen = 32
+)
+
 // Size is the signature size
 type Size int
 
@@ -29,54 +50,153 @@ type SigningMethod interface {
 	Method() jwt.SigningMethod
 }
 
+// KeySet is implemented by signing methods that can enumerate the key ids
+// currently in use, allowing their public keys to be published
+type KeySet interface {
+	KIDs() []interface{}
+}
+
+// HMACSecrets is a named set of HMAC secrets
+type HMACSecrets struct {
+	// Secrets maps key ids to secrets
+	Secrets map[string][]byte
+
+	// Active is the id of the secret used to sign new tokens
+	Active string
+
+	// Legacy enables verification of tokens signed by previous versions of
+	// SigningMethodHMAC using this secret, it is only intended to be set
+	// while migrating
+	Legacy []byte
+}
+
 type signingMethodHMAC struct {
-	secret []byte
-	method *jwt.SigningMethodHMAC
+	secrets HMACSecrets
+	method  *jwt.SigningMethodHMAC
 }
 
 func (s *signingMethodHMAC) KID() interface{} {
-	kid := make([]byte, 10)
-	rand.Read(kid)
-	return base64.StdEncoding.EncodeToString(kid)
+	return s.secrets.Active
 }
 
 func (s *signingMethodHMAC) PublicKey(kid interface{}) interface{} {
-	hash := sha1.New()
-	binary.Write(hash, binary.BigEndian, s.secret)
-	binary.Write(hash, binary.BigEndian, kid)
-	return hash.Sum(nil)
+	if str, ok := kid.(string); ok {
+		if secret, ok := s.secrets.Secrets[str]; ok {
+			return deriveHMACKey(secret, s.method, str)
+		}
+	}
+
+	if s.secrets.Legacy != nil && kid != nil {
+		return legacyHMACKey(s.secrets.Legacy, kid)
+	}
+
+	return nil
 }
 
 func (s *signingMethodHMAC) PrivateKey(kid interface{}) interface{} {
-	return s.PublicKey(kid)
+	if str, ok := kid.(string); ok {
+		if secret, ok := s.secrets.Secrets[str]; ok {
+			return deriveHMACKey(secret, s.method, str)
+		}
+	}
+	return nil
 }
 
 func (s *signingMethodHMAC) Method() jwt.SigningMethod {
 	return s.method
 }
 
-// SigningMethodHMAC implements SigningMethod based off a secret
-func SigningMethodHMAC(secret []byte, size Size) SigningMethod {
-	var method *jwt.SigningMethodHMAC
+// deriveHMACKey derives a key bound to both the algorithm and kid, so the same
+// secret never signs with the same key under two algorithms
+func deriveHMACKey(secret []byte, method *jwt.SigningMethodHMAC, kid string) []byte {
+	key := make([]byte, method.Hash.Size())
+	info := []byte("auth hmac " + method.Alg() + " " + kid)
+	io.ReadFull(hkdf.New(sha256.New, secret, nil, info), key)
+	return key
+}
+
+// legacyHMACKey derives keys the way previous versions did, since kids were
+// always strings binary.Write rejected them and only the secret was hashed
+func legacyHMACKey(secret []byte, kid interface{}) []byte {
+	hash := sha1.New()
+	binary.Write(hash, binary.BigEndian, secret)
+	binary.Write(hash, binary.BigEndian, kid)
+	return hash.Sum(nil)
+}
 
+func hmacMethod(size Size) *jwt.SigningMethodHMAC {
 	switch size {
 	case Size256:
-		method = jwt.SigningMethodHS256
+		return jwt.SigningMethodHS256
 	case Size384:
-		method = jwt.SigningMethodHS384
+		return jwt.SigningMethodHS384
 	case Size512:
-		method = jwt.SigningMethodHS512
+		return jwt.SigningMethodHS512
 	}
+	return nil
+}
 
-	return &signingMethodHMAC{
-		secret: secret,
-		method: method,
+// SigningMethodHMAC implements SigningMethod based off a secret, the kid is
+// derived from the secret. Tokens signed by previous versions do not verify,
+// see SigningMethodHMACLegacy.
+func SigningMethodHMAC(secret []byte, size Size) (SigningMethod, error) {
+	return SigningMethodHMACSecrets(singleHMACSecret(secret), size)
+}
+
+// SigningMethodHMACLegacy is SigningMethodHMAC that also verifies tokens
+// signed by previous versions. It is only intended for migrating, switch to
+// SigningMethodHMAC once those tokens have expired.
+func SigningMethodHMACLegacy(secret []byte, size Size) (SigningMethod, error) {
+	secrets := singleHMACSecret(secret)
+	secrets.Legacy = secret
+	return SigningMethodHMACSecrets(secrets, size)
+}
+
+// singleHMACSecret names a secret with a kid derived from it
+func singleHMACSecret(secret []byte) HMACSecrets {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("auth hmac kid"))
+	kid := jwt.EncodeSegment(mac.Sum(nil)[:9])
+
+	return HMACSecrets{
+		Secrets: map[string][]byte{kid: secret},
+		Active:  kid,
+	}
+}
+
+// SigningMethodHMACSecrets implements SigningMethod based off a set of named
+// secrets, tokens are signed with the active secret and verified with the
+// secret matching their kid
+func SigningMethodHMACSecrets(secrets HMACSecrets, size Size) (SigningMethod, error) {
+	method := hmacMethod(size)
+	if method == nil {
+		return nil, ErrSizeInvalid
+	}
+
+	if _, ok := secrets.Secrets[secrets.Active]; !ok {
+		return nil, ErrSecretRequired
+	}
+
+	for _, secret := range secrets.Secrets {
+		if len(secret) < MinHMACSecretLen {
+			return nil, ErrKeyTooSmall
+		}
+	}
+
+	if secrets.Legacy != nil && len(secrets.Legacy) < MinHMACSecretLen {
+		return nil, ErrKeyTooSmall
 	}
+
+	return &signingMethodHMAC{
+		secrets: secrets,
+		method:  method,
+	}, nil
 }
 
 // ECDSAKeyGen gets ecdsa keys based on an id
 type ECDSAKeyGen interface {
 	KID() interface{}
+	KIDs() []interface{}
 	Key(kid interface{}) *ecdsa.PrivateKey
 }
 
@@ -88,6 +208,10 @@ func (s *simpleECDSAKeyGen) KID() interface{} {
 	return nil
 }
 
+func (s *simpleECDSAKeyGen) KIDs() []interface{} {
+	return []interface{}{nil}
+}
+
 func (s *simpleECDSAKeyGen) Key(kid interface{}) *ecdsa.PrivateKey {
 	return s.key
 }
@@ -104,8 +228,9 @@ type signingMethodECDSA struct {
 	method *jwt.SigningMethodECDSA
 }
 
-// SigningMethodECDSA implements SigningMethod based off a private key
-func SigningMethodECDSA(keygen ECDSAKeyGen, size Size) SigningMethod {
+// SigningMethodECDSA implements SigningMethod based off a private key, the
+// curve of every key must match the size
+func SigningMethodECDSA(keygen ECDSAKeyGen, size Size) (SigningMethod, error) {
 	var method *jwt.SigningMethodECDSA
 
 	switch size {
@@ -115,24 +240,48 @@ func SigningMethodECDSA(keygen ECDSAKeyGen, size Size) SigningMethod {
 		method = jwt.SigningMethodES384
 	case Size512:
 		method = jwt.SigningMethodES512
+	default:
+		return nil, ErrSizeInvalid
+	}
+
+	// Keyrings can be empty, the curve of the keys they generate is checked
+	if c, ok := keygen.(interface{ Curve() elliptic.Curve }); ok && c.Curve().Params().BitSize != method.CurveBits {
+		return nil, ErrKeyAlgorithmMismatch
+	}
+
+	for _, kid := range keygen.KIDs() {
+		if key := keygen.Key(kid); key != nil && key.Curve.Params().BitSize != method.CurveBits {
+			return nil, ErrKeyAlgorithmMismatch
+		}
 	}
 
 	return &signingMethodECDSA{
 		keygen: keygen,
 		method: method,
-	}
+	}, nil
 }
 
 func (s *signingMethodECDSA) KID() interface{} {
 	return s.keygen.KID()
 }
 
+func (s *signingMethodECDSA) KIDs() []interface{} {
+	return s.keygen.KIDs()
+}
+
 func (s *signingMethodECDSA) PublicKey(kid interface{}) interface{} {
-	return &(s.keygen.Key(kid).PublicKey)
+	if key := s.keygen.Key(kid); key != nil {
+		return &key.PublicKey
+	}
+	return nil
 }
 
 func (s *signingMethodECDSA) PrivateKey(kid interface{}) interface{} {
-	return s.keygen.Key(kid)
+	// Keys added after the method was created are checked again
+	if key := s.keygen.Key(kid); key != nil && key.Curve.Params().BitSize == s.method.CurveBits {
+		return key
+	}
+	return nil
 }
 
 func (s *signingMethodECDSA) Method() jwt.SigningMethod {
@@ -142,6 +291,7 @@ func (s *signingMethodECDSA) Method() jwt.SigningMethod {
 // RSAKeyGen gets rsa keys based on an id
 type RSAKeyGen interface {
 	KID() interface{}
+	KIDs() []interface{}
 	Key(kid interface{}) *rsa.PrivateKey
 }
 
@@ -153,6 +303,10 @@ func (s *simpleRSAKeyGen) KID() interface{} {
 	return nil
 }
 
+func (s *simpleRSAKeyGen) KIDs() []interface{} {
+	return []interface{}{nil}
+}
+
 func (s *simpleRSAKeyGen) Key(kid interface{}) *rsa.PrivateKey {
 	return s.key
 }
@@ -164,13 +318,23 @@ func SimpleRSAKeyGen(key *rsa.PrivateKey) RSAKeyGen {
 	}
 }
 
+func validateRSAKeys(keygen RSAKeyGen) error {
+	for _, kid := range keygen.KIDs() {
+		if key := keygen.Key(kid); key != nil && key.N.BitLen() < MinRSAKeySize {
+			return ErrKeyTooSmall
+		}
+	}
+	return nil
+}
+
 type signingMethodRSA struct {
 	keygen RSAKeyGen
 	method *jwt.SigningMethodRSA
 }
 
-// SigningMethodRSA implements SigningMethod based off a private key
-func SigningMethodRSA(keygen RSAKeyGen, size Size) SigningMethod {
+// SigningMethodRSA implements SigningMethod based off a private key, keys
+// must be at least MinRSAKeySize bits
+func SigningMethodRSA(keygen RSAKeyGen, size Size) (SigningMethod, error) {
 	var method *jwt.SigningMethodRSA
 
 	switch size {
@@ -180,24 +344,41 @@ func SigningMethodRSA(keygen RSAKeyGen, size Size) SigningMethod {
 		method = jwt.SigningMethodRS384
 	case Size512:
 		method = jwt.SigningMethodRS512
+	default:
+		return nil, ErrSizeInvalid
+	}
+
+	if err := validateRSAKeys(keygen); err != nil {
+		return nil, err
 	}
 
 	return &signingMethodRSA{
 		keygen: keygen,
 		method: method,
-	}
+	}, nil
 }
 
 func (s *signingMethodRSA) KID() interface{} {
 	return s.keygen.KID()
 }
 
+func (s *signingMethodRSA) KIDs() []interface{} {
+	return s.keygen.KIDs()
+}
+
 func (s *signingMethodRSA) PublicKey(kid interface{}) interface{} {
-	return &(s.keygen.Key(kid).PublicKey)
+	if key := s.keygen.Key(kid); key != nil {
+		return &key.PublicKey
+	}
+	return nil
 }
 
 func (s *signingMethodRSA) PrivateKey(kid interface{}) interface{} {
-	return s.keygen.Key(kid)
+	// Keys added after the method was created are checked again
+	if key := s.keygen.Key(kid); key != nil && key.N.BitLen() >= MinRSAKeySize {
+		return key
+	}
+	return nil
 }
 
 func (s *signingMethodRSA) Method() jwt.SigningMethod {
@@ -209,8 +390,9 @@ type signingMethodRSAPSS struct {
 	method *jwt.SigningMethodRSAPSS
 }
 
-// SigningMethodRSAPSS implements SigningMethod based off a private key
-func SigningMethodRSAPSS(keygen RSAKeyGen, size Size) SigningMethod {
+// SigningMethodRSAPSS implements SigningMethod based off a private key, keys
+// must be at least MinRSAKeySize bits
+func SigningMethodRSAPSS(keygen RSAKeyGen, size Size) (SigningMethod, error) {
 	var method *jwt.SigningMethodRSAPSS
 
 	switch size {
@@ -220,26 +402,158 @@ func SigningMethodRSAPSS(keygen RSAKeyGen, size Size) SigningMethod {
 		method = jwt.SigningMethodPS384
 	case Size512:
 		method = jwt.SigningMethodPS512
+	default:
+		return nil, ErrSizeInvalid
+	}
+
+	if err := validateRSAKeys(keygen); err != nil {
+		return nil, err
 	}
 
 	return &signingMethodRSAPSS{
 		keygen: keygen,
 		method: method,
-	}
+	}, nil
 }
 
 func (s *signingMethodRSAPSS) KID() interface{} {
 	return s.keygen.KID()
 }
 
+func (s *signingMethodRSAPSS) KIDs() []interface{} {
+	return s.keygen.KIDs()
+}
+
 func (s *signingMethodRSAPSS) PublicKey(kid interface{}) interface{} {
-	return &(s.keygen.Key(kid).PublicKey)
+	if key := s.keygen.Key(kid); key != nil {
+		return &key.PublicKey
+	}
+	return nil
 }
 
 func (s *signingMethodRSAPSS) PrivateKey(kid interface{}) interface{} {
-	return s.keygen.Key(kid)
+	// Keys added after the method was created are checked again
+	if key := s.keygen.Key(kid); key != nil && key.N.BitLen() >= MinRSAKeySize {
+		return key
+	}
+	return nil
 }
 
 func (s *signingMethodRSAPSS) Method() jwt.SigningMethod {
 	return s.method
 }
+
+// jwt-go does not implement EdDSA (RFC 8037) so it is registered here
+type jwtSigningMethodEdDSA struct{}
+
+var jwtEdDSA = &jwtSigningMethodEdDSA{}
+
+func init() {
+	jwt.RegisterSigningMethod(jwtEdDSA.Alg(), func() jwt.SigningMethod {
+		return jwtEdDSA
+	})
+}
+
+func (m *jwtSigningMethodEdDSA) Alg() string {
+	return "EdDSA"
+}
+
+func (m *jwtSigningMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok || len(publicKey) != ed25519.PublicKeySize {
+		return jwt.ErrInvalidKey
+	}
+
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(publicKey, []byte(signingString), sig) {
+		return ErrSignatureInvalid
+	}
+	return nil
+}
+
+func (m *jwtSigningMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok || len(privateKey) != ed25519.PrivateKeySize {
+		return "", jwt.ErrInvalidKey
+	}
+
+	return jwt.EncodeSegment(ed25519.Sign(privateKey, []byte(signingString))), nil
+}
+
+// Ed25519KeyGen gets ed25519 keys based on an id
+type Ed25519KeyGen interface {
+	KID() interface{}
+	KIDs() []interface{}
+	Key(kid interface{}) ed25519.PrivateKey
+}
+
+type simpleEd25519KeyGen struct {
+	key ed25519.PrivateKey
+}
+
+func (s *simpleEd25519KeyGen) KID() interface{} {
+	return nil
+}
+
+func (s *simpleEd25519KeyGen) KIDs() []interface{} {
+	return []interface{}{nil}
+}
+
+func (s *simpleEd25519KeyGen) Key(kid interface{}) ed25519.PrivateKey {
+	return s.key
+}
+
+// SimpleEd25519KeyGen ignores the kid and returnes a consitent private key
+func SimpleEd25519KeyGen(key ed25519.PrivateKey) Ed25519KeyGen {
+	return &simpleEd25519KeyGen{
+		key: key,
+	}
+}
+
+type signingMethodEd25519 struct {
+	keygen Ed25519KeyGen
+}
+
+// SigningMethodEd25519 implements SigningMethod based off a private key, the
+// signature size is fixed so unlike the other methods it takes no Size
+func SigningMethodEd25519(keygen Ed25519KeyGen) (SigningMethod, error) {
+	for _, kid := range keygen.KIDs() {
+		if key := keygen.Key(kid); key != nil && len(key) != ed25519.PrivateKeySize {
+			return nil, ErrKeyInvalid
+		}
+	}
+
+	return &signingMethodEd25519{
+		keygen: keygen,
+	}, nil
+}
+
+func (s *signingMethodEd25519) KID() interface{} {
+	return s.keygen.KID()
+}
+
+func (s *signingMethodEd25519) KIDs() []interface{} {
+	return s.keygen.KIDs()
+}
+
+func (s *signingMethodEd25519) PublicKey(kid interface{}) interface{} {
+	if key := s.keygen.Key(kid); key != nil {
+		return key.Public()
+	}
+	return nil
+}
+
+func (s *signingMethodEd25519) PrivateKey(kid interface{}) interface{} {
+	if key := s.keygen.Key(kid); key != nil {
+		return key
+	}
+	return nil
+}
+
+func (s *signingMethodEd25519) Method() jwt.SigningMethod {
+	return jwtEdDSA
+}
@@ -2,11 +2,15 @@ package auth
 
 import (
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"encoding/base64"
 	"testing"
 
+	"gopkg.in/dgrijalva/jwt-go.v2"
+
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -19,9 +23,12 @@ func TestSigningMethodHMAC(t *testing.T) {
 		secret := make([]byte, 50)
 		rand.Read(secret)
 
-		method1 := SigningMethodHMAC(secret, Size256)
-		method2 := SigningMethodHMAC(secret, Size384)
-		method3 := SigningMethodHMAC(secret, Size512)
+		method1, err := SigningMethodHMAC(secret, Size256)
+		So(err, ShouldBeNil)
+		method2, err := SigningMethodHMAC(secret, Size384)
+		So(err, ShouldBeNil)
+		method3, err := SigningMethodHMAC(secret, Size512)
+		So(err, ShouldBeNil)
 
 		Convey("When a string is signed", func() {
 
@@ -50,6 +57,113 @@ func TestSigningMethodHMAC(t *testing.T) {
 	})
 }
 
+func TestSigningMethodHMACSecrets(t *testing.T) {
+	if !testing.Verbose() {
+		t.Parallel()
+	}
+
+	Convey("Given a HMAC signing method with multiple secrets", t, func() {
+		secrets := map[string][]byte{
+			"old": []byte("old secret that is at least 32 bytes"),
+			"new": []byte("new secret that is at least 32 bytes"),
+		}
+
+		oldMethod, err := SigningMethodHMACSecrets(HMACSecrets{Secrets: secrets, Active: "old"}, Size256)
+		So(err, ShouldBeNil)
+		newMethod, err := SigningMethodHMACSecrets(HMACSecrets{Secrets: secrets, Active: "new"}, Size256)
+		So(err, ShouldBeNil)
+
+		oldGenerator := NewTokenGenerator(oldMethod)
+		newGenerator := NewTokenGenerator(newMethod)
+
+		Convey("When a token is signed with the old secret", func() {
+			token := oldGenerator.Create()
+			str, err := oldGenerator.Sign(token)
+			So(err, ShouldBeNil)
+
+			Convey("Then the kid should name the secret", func() {
+				So(token.Header["kid"], ShouldEqual, "old")
+			})
+
+			Convey("Then the token should verify after switching secrets", func() {
+				_, err := newGenerator.Verify(str)
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When a token has a kid that is not in the set", func() {
+			otherMethod, err := SigningMethodHMACSecrets(HMACSecrets{
+				Secrets: map[string][]byte{"other": []byte("old secret that is at least 32 bytes")},
+				Active:  "other",
+			}, Size256)
+			So(err, ShouldBeNil)
+			other := NewTokenGenerator(otherMethod)
+
+			str, err := other.Sign(other.Create())
+			So(err, ShouldBeNil)
+
+			Convey("Then the token should not verify", func() {
+				parsed, err := newGenerator.Verify(str)
+				So(parsed, ShouldBeNil)
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When keys are derived for different algorithms", func() {
+			method384, err := SigningMethodHMACSecrets(HMACSecrets{Secrets: secrets, Active: "new"}, Size384)
+			So(err, ShouldBeNil)
+
+			Convey("Then the keys should differ", func() {
+				So(method384.PrivateKey("new"), ShouldNotResemble, newMethod.PrivateKey("new"))
+			})
+		})
+	})
+
+	Convey("Given a HMAC signing method created from a single secret", t, func() {
+		secret := make([]byte, 50)
+		rand.Read(secret)
+
+		method, err := SigningMethodHMAC(secret, Size512)
+		So(err, ShouldBeNil)
+		generator := NewTokenGenerator(method)
+
+		Convey("When the kid is requested multiple times", func() {
+			Convey("Then the kid should be deterministic", func() {
+				So(method.KID(), ShouldEqual, method.KID())
+				again, err := SigningMethodHMAC(secret, Size512)
+				So(err, ShouldBeNil)
+				So(again.KID(), ShouldEqual, method.KID())
+			})
+		})
+
+		Convey("When a token minted by the legacy random kid scheme is verified", func() {
+			kid := make([]byte, 10)
+			rand.Read(kid)
+
+			token := jwt.New(jwt.SigningMethodHS512)
+			token.Header["kid"] = base64.StdEncoding.EncodeToString(kid)
+			str, err := token.SignedString(legacyHMACKey(secret, token.Header["kid"]))
+			So(err, ShouldBeNil)
+
+			Convey("Then the token should not be valid", func() {
+				parsed, err := generator.Verify(str)
+				So(parsed, ShouldBeNil)
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("Then the token should be valid with the legacy method", func() {
+				legacyMethod, err := SigningMethodHMACLegacy(secret, Size512)
+				So(err, ShouldBeNil)
+				So(legacyMethod.KID(), ShouldEqual, method.KID())
+				legacy := NewTokenGenerator(legacyMethod)
+
+				_, err = legacy.Verify(str)
+				So(err, ShouldBeNil)
+			})
+		})
+	})
+}
+
 func TestSigningMethodECDSA(t *testing.T) {
 	if !testing.Verbose() {
 		t.Parallel()
@@ -72,9 +186,12 @@ func TestSigningMethodECDSA(t *testing.T) {
 		keygen2 := SimpleECDSAKeyGen(key2)
 		keygen3 := SimpleECDSAKeyGen(key3)
 
-		method1 := SigningMethodECDSA(keygen1, Size256)
-		method2 := SigningMethodECDSA(keygen2, Size384)
-		method3 := SigningMethodECDSA(keygen3, Size512)
+		method1, err := SigningMethodECDSA(keygen1, Size256)
+		So(err, ShouldBeNil)
+		method2, err := SigningMethodECDSA(keygen2, Size384)
+		So(err, ShouldBeNil)
+		method3, err := SigningMethodECDSA(keygen3, Size512)
+		So(err, ShouldBeNil)
 
 		Convey("When a string is signed", func() {
 
@@ -109,14 +226,17 @@ func TestSigningMethodRSA(t *testing.T) {
 	}
 
 	Convey("Given a RSA signing method with a random key", t, func() {
-		key, err := rsa.GenerateKey(rand.Reader, 1024)
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
 		So(err, ShouldBeNil)
 
 		keygen := SimpleRSAKeyGen(key)
 
-		method1 := SigningMethodRSA(keygen, Size256)
-		method2 := SigningMethodRSA(keygen, Size384)
-		method3 := SigningMethodRSA(keygen, Size512)
+		method1, err := SigningMethodRSA(keygen, Size256)
+		So(err, ShouldBeNil)
+		method2, err := SigningMethodRSA(keygen, Size384)
+		So(err, ShouldBeNil)
+		method3, err := SigningMethodRSA(keygen, Size512)
+		So(err, ShouldBeNil)
 
 		Convey("When a string is signed", func() {
 
@@ -151,14 +271,17 @@ func TestSigningMethodRSAPSS(t *testing.T) {
 	}
 
 	Convey("Given a RSAPSS signing method with a random key", t, func() {
-		key, err := rsa.GenerateKey(rand.Reader, 1024)
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
 		So(err, ShouldBeNil)
 
 		keygen := SimpleRSAKeyGen(key)
 
-		method1 := SigningMethodRSAPSS(keygen, Size256)
-		method2 := SigningMethodRSAPSS(keygen, Size384)
-		method3 := SigningMethodRSAPSS(keygen, Size512)
+		method1, err := SigningMethodRSAPSS(keygen, Size256)
+		So(err, ShouldBeNil)
+		method2, err := SigningMethodRSAPSS(keygen, Size384)
+		So(err, ShouldBeNil)
+		method3, err := SigningMethodRSAPSS(keygen, Size512)
+		So(err, ShouldBeNil)
 
 		Convey("When a string is signed", func() {
 
@@ -187,9 +310,152 @@ func TestSigningMethodRSAPSS(t *testing.T) {
 	})
 }
 
+func TestSigningMethodEd25519(t *testing.T) {
+	if !testing.Verbose() {
+		t.Parallel()
+	}
+
+	// Test vectors from RFC 8037 appendix A
+	const (
+		rfcPrivate   = "nWGxne_9WmC6hEr0kuwsxERJxWl7MmkZcDusAxyuf2A"
+		rfcPublic    = "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"
+		rfcInput     = "eyJhbGciOiJFZERTQSJ9.RXhhbXBsZSBvZiBFZDI1NTE5IHNpZ25pbmc"
+		rfcSignature = "hgyY0il_MGCjP0JzlnLWG1PPOt7-09PGcvMg3AIbQR6dWbhijcNR4ki4iylGjg5BhVsPt9g7sVvpAr_MuM0KAg"
+	)
+
+	Convey("Given a Ed25519 signing method using the RFC 8037 key", t, func() {
+		seed, err := jwt.DecodeSegment(rfcPrivate)
+		So(err, ShouldBeNil)
+
+		key := ed25519.NewKeyFromSeed(seed)
+		method, err := SigningMethodEd25519(SimpleEd25519KeyGen(key))
+		So(err, ShouldBeNil)
+
+		Convey("When the public key is published", func() {
+			jwks, err := NewJWKS(method)
+			So(err, ShouldBeNil)
+
+			Convey("Then it should match the RFC", func() {
+				So(jwks.Keys, ShouldHaveLength, 1)
+				So(jwks.Keys[0].KeyType, ShouldEqual, "OKP")
+				So(jwks.Keys[0].Curve, ShouldEqual, "Ed25519")
+				So(jwks.Keys[0].Algorithm, ShouldEqual, "EdDSA")
+				So(jwks.Keys[0].X, ShouldEqual, rfcPublic)
+			})
+		})
+
+		Convey("When the RFC signing input is signed", func() {
+			signed, err := method.Method().Sign(rfcInput, method.PrivateKey(method.KID()))
+			So(err, ShouldBeNil)
+
+			Convey("Then the signature should match the RFC", func() {
+				So(signed, ShouldEqual, rfcSignature)
+			})
+		})
+
+		Convey("When the RFC signature is verified using the published key", func() {
+			jwk := &JWK{KeyType: "OKP", Curve: "Ed25519", X: rfcPublic}
+			public, err := jwk.PublicKey()
+			So(err, ShouldBeNil)
+
+			err = jwt.GetSigningMethod("EdDSA").Verify(rfcInput, rfcSignature, public)
+
+			Convey("Then the signature should be valid", func() {
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When a tampered signing input is verified", func() {
+			err := method.Method().Verify(rfcInput+"x", rfcSignature, method.PublicKey(method.KID()))
+
+			Convey("Then the signature should be invalid", func() {
+				So(err, ShouldEqual, ErrSignatureInvalid)
+			})
+		})
+
+		Convey("When a token is signed and verified", func() {
+			generator := NewTokenGenerator(method)
+			token := generator.Create()
+			token.Claims["foo"] = "bar"
+
+			str, err := generator.Sign(token)
+			So(err, ShouldBeNil)
+
+			parsed, err := generator.Verify(str)
+
+			Convey("Then the token should be valid", func() {
+				So(err, ShouldBeNil)
+				So(parsed.Claims["foo"], ShouldEqual, "bar")
+			})
+		})
+	})
+}
+
+func TestSigningMethodErrors(t *testing.T) {
+	if !testing.Verbose() {
+		t.Parallel()
+	}
+
+	Convey("Given keys that can not be used", t, func() {
+		Convey("When a HMAC method is created with an invalid size", func() {
+			method, err := SigningMethodHMAC(make([]byte, 50), Size(100))
+
+			Convey("Then ErrSizeInvalid should be returned", func() {
+				So(method, ShouldBeNil)
+				So(err, ShouldEqual, ErrSizeInvalid)
+			})
+		})
+
+		Convey("When a HMAC method is created with a short secret", func() {
+			method, err := SigningMethodHMAC([]byte("secret"), Size256)
+
+			Convey("Then ErrKeyTooSmall should be returned", func() {
+				So(method, ShouldBeNil)
+				So(err, ShouldEqual, ErrKeyTooSmall)
+			})
+		})
+
+		Convey("When a HMAC method is created without an active secret", func() {
+			method, err := SigningMethodHMACSecrets(HMACSecrets{
+				Secrets: map[string][]byte{"kid": make([]byte, 50)},
+			}, Size256)
+
+			Convey("Then ErrSecretRequired should be returned", func() {
+				So(method, ShouldBeNil)
+				So(err, ShouldEqual, ErrSecretRequired)
+			})
+		})
+
+		Convey("When a RSA method is created with a 1024 bit key", func() {
+			key, err := rsa.GenerateKey(rand.Reader, 1024)
+			So(err, ShouldBeNil)
+
+			method, err := SigningMethodRSA(SimpleRSAKeyGen(key), Size256)
+
+			Convey("Then ErrKeyTooSmall should be returned", func() {
+				So(method, ShouldBeNil)
+				So(err, ShouldEqual, ErrKeyTooSmall)
+			})
+		})
+
+		Convey("When a ECDSA method is created with a P-256 key and ES512", func() {
+			key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			So(err, ShouldBeNil)
+
+			method, err := SigningMethodECDSA(SimpleECDSAKeyGen(key), Size512)
+
+			Convey("Then ErrKeyAlgorithmMismatch should be returned", func() {
+				So(method, ShouldBeNil)
+				So(err, ShouldEqual, ErrKeyAlgorithmMismatch)
+			})
+		})
+	})
+}
+
 func NewMockSigningMethod() SigningMethod {
 	secret := make([]byte, 50)
 	rand.Read(secret)
 
-	return SigningMethodHMAC(secret, Size512)
+	method, _ := SigningMethodHMAC(secret, Size512)
+	return method
 }
@@ -1,12 +1,80 @@
 package auth
 
+import (
+	"context"
+	"net/http"
+)
+
 // User contains uid and permissions
 type User struct {
 	UID         string
 	Permissions []string
+
+	// Roles are expanded into Permissions when tokens are validated, see
+	// WithRoles
+	Roles []string
+
+	// ClientID is the OAuth 2.0 client the token was issued to. Tokens from
+	// the client_credentials grant have no UID, see IsClient.
+	ClientID string
+
+	// Claims are additional claims carried in tokens, such as a tenant id or
+	// email address. Reserved claim names can not be used.
+	Claims map[string]interface{}
+}
+
+// IsClient checks if the user is an OAuth 2.0 client acting for itself rather
+// than a user
+func (u *User) IsClient() bool {
+	return u.UID == "" && u.ClientID != ""
+}
+
+// ReservedClaims are claim names used by the package that can not be set in
+// User.Claims
+var ReservedClaims = []string{
+	"exp", "nbf", "iat", "iss", "aud", "sub", "jti", "auth_time", "sid",
+	"uid", "type", "permissions", "roles", "fam", "client_id",
+}
+
+// IsReservedClaim checks if a claim name is reserved
+func IsReservedClaim(name string) bool {
+	for _, reserved := range ReservedClaims {
+		if name == reserved {
+			return true
+		}
+	}
+	return false
 }
 
 // Storage implements account storage
 type Storage interface {
 	Authenticate(user string, pass string) (*User, error)
 }
+
+// ContextStorage implements account storage that honours the deadline and
+// cancellation of the context. The request is the login request, its body has
+// already been read. It is nil if the login was not made over HTTP.
+type ContextStorage interface {
+	AuthenticateContext(ctx context.Context, r *http.Request, user string, pass string) (*User, error)
+}
+
+// StorageWithContext adapts a Storage to ContextStorage, the context is only
+// checked before Authenticate is called. Storage that already implements
+// ContextStorage is returned as is.
+func StorageWithContext(storage Storage) ContextStorage {
+	if s, ok := storage.(ContextStorage); ok {
+		return s
+	}
+	return &legacyStorage{storage}
+}
+
+type legacyStorage struct {
+	storage Storage
+}
+
+func (s *legacyStorage) AuthenticateContext(ctx context.Context, r *http.Request, user string, pass string) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.storage.Authenticate(user, pass)
+}
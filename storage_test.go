@@ -1,12 +1,27 @@
 package auth
 
-import "errors"
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"gopkg.in/dgrijalva/jwt-go.v2"
+)
 
 type mockStorage struct {
 	UID         string
 	Username    string
 	Password    string
 	Permissions []string
+	Claims      map[string]interface{}
 }
 
 func NewMockStorage(uid string, user string, pass string, permissions []string) Storage {
@@ -20,7 +35,9 @@ func NewMockStorage(uid string, user string, pass string, permissions []string)
 
 func (m *mockStorage) Authenticate(user string, pass string) (*User, error) {
 	if m.Username == user && m.Password == pass {
-		return NewMockUser(m.UID, m.Permissions...), nil
+		user := NewMockUser(m.UID, m.Permissions...)
+		user.Claims = m.Claims
+		return user, nil
 	}
 
 	return nil, errors.New("User not found")
@@ -32,3 +49,192 @@ func NewMockUser(uid string, permissions ...string) *User {
 		Permissions: permissions,
 	}
 }
+
+type mockContextStorage struct {
+	mockStorage
+	ctx     context.Context
+	request *http.Request
+}
+
+func (m *mockContextStorage) AuthenticateContext(ctx context.Context, r *http.Request, user string, pass string) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.ctx = ctx
+	m.request = r
+	return m.Authenticate(user, pass)
+}
+
+func TestStorageWithContext(t *testing.T) {
+	if !testing.Verbose() {
+		t.Parallel()
+	}
+
+	Convey("Given legacy storage", t, func() {
+		storage := StorageWithContext(NewMockStorage("test_uid", "test_user", "test_pass", nil))
+
+		Convey("When authenticating with a context", func() {
+			user, err := storage.AuthenticateContext(context.Background(), nil, "test_user", "test_pass")
+
+			Convey("Then the user should be returned", func() {
+				So(err, ShouldBeNil)
+				So(user.UID, ShouldEqual, "test_uid")
+			})
+		})
+
+		Convey("When authenticating with a cancelled context", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			user, err := storage.AuthenticateContext(ctx, nil, "test_user", "test_pass")
+
+			Convey("Then the context error should be returned", func() {
+				So(user, ShouldBeNil)
+				So(err, ShouldEqual, context.Canceled)
+			})
+		})
+	})
+
+	Convey("Given storage that implements ContextStorage", t, func() {
+		storage := &mockContextStorage{}
+
+		Convey("When it is adapted", func() {
+			adapted := StorageWithContext(storage)
+
+			Convey("Then it should be returned as is", func() {
+				So(adapted, ShouldEqual, storage)
+			})
+		})
+	})
+}
+
+func TestHandlerContextStorage(t *testing.T) {
+	if !testing.Verbose() {
+		t.Parallel()
+	}
+
+	Convey("Given a handler with context storage", t, func() {
+		storage := &mockContextStorage{
+			mockStorage: mockStorage{UID: "test_uid", Username: "test_user", Password: "test_pass"},
+		}
+		auth := NewAuthenticator(NewMockTokenGenerator(), nil, time.Hour, time.Hour,
+			WithContextStorage(storage),
+		)
+		handler := NewHandler(auth)
+
+		body := `{"username": "test_user", "password": "test_pass"}`
+		r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("User-Agent", "agent")
+
+		Convey("When logging in", func() {
+			type key struct{}
+			ctx := context.WithValue(context.Background(), key{}, "value")
+			w := httptest.NewRecorder()
+			handler.CtxServeHTTP(ctx, w, r)
+
+			Convey("Then the context and request should be passed to the storage", func() {
+				So(storage.ctx.Value(key{}), ShouldEqual, "value")
+				So(storage.request.RemoteAddr, ShouldEqual, r.RemoteAddr)
+				So(storage.request.UserAgent(), ShouldEqual, "agent")
+				So(w.Body.String(), ShouldContainSubstring, `"token"`)
+			})
+		})
+
+		Convey("When logging in with a Signer", func() {
+			signer := &contextSigner{secret: []byte("01234567890123456789012345678901")}
+			generator, err := NewSignerTokenGenerator(signer)
+			So(err, ShouldBeNil)
+			auth.generator = generator
+
+			type key struct{}
+			ctx := context.WithValue(context.Background(), key{}, "value")
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r.WithContext(ctx))
+
+			Convey("Then the context should be passed to the Signer", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(signer.contexts, ShouldHaveLength, 2)
+				for _, signed := range signer.contexts {
+					So(signed.Value(key{}), ShouldEqual, "value")
+				}
+			})
+		})
+
+		Convey("When refreshing with a cancelled context", func() {
+			signer := &contextSigner{secret: []byte("01234567890123456789012345678901")}
+			generator, err := NewSignerTokenGenerator(signer)
+			So(err, ShouldBeNil)
+			auth.generator = generator
+			auth.verifier = generator
+
+			refresh, err := auth.GenerateRefresh(&User{UID: "test_uid"})
+			So(err, ShouldBeNil)
+
+			body := `{"refresh_token": "` + refresh + `"}`
+			r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+			r.Header.Set("Content-Type", "application/json")
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r.WithContext(ctx))
+
+			Convey("Then the cancelled context should reach the Signer", func() {
+				So(w.Code, ShouldNotEqual, http.StatusOK)
+				So(w.Body.String(), ShouldContainSubstring, `"error"`)
+				So(signer.contexts, ShouldHaveLength, 2)
+				So(signer.contexts[1].Err(), ShouldEqual, context.Canceled)
+			})
+		})
+
+		Convey("When logging in with a cancelled context", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			w := httptest.NewRecorder()
+			handler.CtxServeHTTP(ctx, w, r)
+
+			Convey("Then an error should be returned", func() {
+				So(w.Body.String(), ShouldContainSubstring, `"error"`)
+				So(storage.request, ShouldBeNil)
+			})
+		})
+	})
+}
+
+// contextSigner is a HMAC Signer that records the contexts it is passed, if
+// err is set signing fails with it
+type contextSigner struct {
+	secret   []byte
+	contexts []context.Context
+	err      error
+}
+
+func (s *contextSigner) KID() interface{} {
+	return nil
+}
+
+func (s *contextSigner) PublicKey(kid interface{}) interface{} {
+	return s.secret
+}
+
+func (s *contextSigner) Method() jwt.SigningMethod {
+	return jwt.SigningMethodHS256
+}
+
+func (s *contextSigner) Sign(ctx context.Context, signingInput []byte) ([]byte, error) {
+	s.contexts = append(s.contexts, ctx)
+	if s.err != nil {
+		return nil, s.err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write(signingInput)
+	return mac.Sum(nil), nil
+}
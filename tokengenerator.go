@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"context"
 	"errors"
 
 	"gopkg.in/dgrijalva/jwt-go.v2"
@@ -13,16 +14,38 @@ var (
 
 // TokenGenerator generates and verifies tokens
 type TokenGenerator struct {
-	method SigningMethod
+	method  SigningMethod
+	signer  Signer
+	options *verifierOptions
 }
 
-// NewTokenGenerator creates a new token generator
-func NewTokenGenerator(method SigningMethod) *TokenGenerator {
+// NewTokenGenerator creates a new token generator, by default only tokens
+// using the algorithm of the signing method are accepted
+func NewTokenGenerator(method SigningMethod, options ...VerifierOption) *TokenGenerator {
+	o := newVerifierOptions(options)
+	if o.algorithms == nil {
+		o.algorithms = []string{method.Method().Alg()}
+	}
+
 	return &TokenGenerator{
-		method: method,
+		method:  method,
+		options: o,
 	}
 }
 
+// NewSignerTokenGenerator creates a new token generator that signs tokens
+// using a Signer, so the private key never has to be in memory
+func NewSignerTokenGenerator(signer Signer, options ...VerifierOption) (*TokenGenerator, error) {
+	method, err := SigningMethodSigner(signer)
+	if err != nil {
+		return nil, err
+	}
+
+	generator := NewTokenGenerator(method, options...)
+	generator.signer = signer
+	return generator, nil
+}
+
 // Create creates a new token using the correct method
 func (t *TokenGenerator) Create() *jwt.Token {
 	return jwt.New(t.method.Method())
@@ -30,25 +53,64 @@ func (t *TokenGenerator) Create() *jwt.Token {
 
 // Sign signs the token and returns its string
 func (t *TokenGenerator) Sign(token *jwt.Token) (string, error) {
-	kid := t.method.KID()
+	return t.SignContext(context.Background(), token)
+}
+
+// SignContext signs the token and returns its string, the context is passed
+// to the Signer if there is one
+func (t *TokenGenerator) SignContext(ctx context.Context, token *jwt.Token) (string, error) {
+	if t.signer == nil {
+		kid := t.method.KID()
+		token.Header["kid"] = kid
+		return token.SignedString(t.method.PrivateKey(kid))
+	}
+
+	input, signature, err := t.signWithSigner(ctx, token)
+
+	// The Signer rotated its key after the kid was read, the new kid is
+	// only tried once so a Signer that keeps rotating can not loop forever
+	if err == ErrSignerKeyChanged {
+		input, signature, err = t.signWithSigner(ctx, token)
+	}
+
+	if err != nil {
+		return "", err
+	}
 
-	token.Header["kid"] = kid
-	return token.SignedString(t.method.PrivateKey(kid))
+	return input + "." + jwt.EncodeSegment(signature), nil
+}
+
+// signWithSigner signs the token with the active kid of the Signer and
+// returns the signing input and signature
+func (t *TokenGenerator) signWithSigner(ctx context.Context, token *jwt.Token) (string, []byte, error) {
+	token.Header["kid"] = t.signer.KID()
+
+	input, err := token.SigningString()
+	if err != nil {
+		return "", nil, err
+	}
+
+	signature, err := t.signer.Sign(ctx, []byte(input))
+	if err != nil {
+		return "", nil, err
+	}
+
+	return input, signature, nil
 }
 
 // Verify verifies and parses a token string
 func (t *TokenGenerator) Verify(str string) (*jwt.Token, error) {
-	token, err := jwt.Parse(str, func(token *jwt.Token) (interface{}, error) {
+	return t.options.parse(str, func(token *jwt.Token) (interface{}, error) {
+		// Keys are only held for the configured algorithm
 		if token.Header["alg"] != t.method.Method().Alg() {
-			return nil, ErrTokenInvalid
+			return nil, ErrAlgorithmNotAllowed
 		}
 
-		return t.method.PublicKey(token.Header["kid"]), nil
-	})
-
-	if err != nil {
-		return nil, err
-	}
+		key := t.method.PublicKey(token.Header["kid"])
+		if key == nil {
+			return nil, ErrUnknownKID
+		}
 
-	return token, nil
+		return key, nil
+	})
 }
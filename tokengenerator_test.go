@@ -6,6 +6,8 @@ import (
 	"testing"
 	"time"
 
+	"gopkg.in/dgrijalva/jwt-go.v2"
+
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -18,7 +20,8 @@ func TestTokenGenerator(t *testing.T) {
 		secret := make([]byte, 50)
 		rand.Read(secret)
 
-		method := SigningMethodHMAC(secret, Size512)
+		method, err := SigningMethodHMAC(secret, Size512)
+		So(err, ShouldBeNil)
 		generator := NewTokenGenerator(method)
 
 		Convey("When a token is signed", func() {
@@ -49,7 +52,38 @@ func TestTokenGenerator(t *testing.T) {
 			Convey("Then the token should be invalid", func() {
 				parsed, err := generator.Verify(str)
 				So(parsed, ShouldBeNil)
-				So(err, ShouldNotBeNil)
+				So(err, ShouldEqual, ErrTokenExpired)
+			})
+		})
+
+		Convey("When a token that is not valid yet is signed", func() {
+			token := generator.Create()
+			token.Claims["nbf"] = time.Now().Add(time.Hour).Unix()
+
+			str, err := generator.Sign(token)
+			So(err, ShouldBeNil)
+
+			Convey("Then ErrTokenNotValidYet should be returned", func() {
+				parsed, err := generator.Verify(str)
+				So(parsed, ShouldBeNil)
+				So(err, ShouldEqual, ErrTokenNotValidYet)
+			})
+		})
+
+		Convey("When a token with a bad signature is verified", func() {
+			other := make([]byte, 50)
+			rand.Read(other)
+
+			token := generator.Create()
+			token.Header["kid"] = method.KID()
+			token.Claims["exp"] = time.Now().Add(-time.Hour).Unix()
+			str, err := token.SignedString(other)
+			So(err, ShouldBeNil)
+
+			Convey("Then ErrSignatureInvalid should be returned over the expiry", func() {
+				parsed, err := generator.Verify(str)
+				So(parsed, ShouldBeNil)
+				So(err, ShouldEqual, ErrSignatureInvalid)
 			})
 		})
 
@@ -58,16 +92,17 @@ func TestTokenGenerator(t *testing.T) {
 
 			Convey("Then an error should be returned", func() {
 				So(parsed, ShouldBeNil)
-				So(err, ShouldNotBeNil)
+				So(err, ShouldEqual, ErrTokenMalformed)
 			})
 		})
 
 		Convey("When an token with an invalid alg is verified", func() {
-			key, err := rsa.GenerateKey(rand.Reader, 1024)
+			key, err := rsa.GenerateKey(rand.Reader, 2048)
 			So(err, ShouldBeNil)
 
 			keygen := SimpleRSAKeyGen(key)
-			methodRSA := SigningMethodRSAPSS(keygen, Size256)
+			methodRSA, err := SigningMethodRSAPSS(keygen, Size256)
+			So(err, ShouldBeNil)
 			generatorRSA := NewTokenGenerator(methodRSA)
 
 			token := generatorRSA.Create()
@@ -77,7 +112,182 @@ func TestTokenGenerator(t *testing.T) {
 			Convey("Then an error should be returned", func() {
 				parsed, err := generator.Verify(str)
 				So(parsed, ShouldBeNil)
-				So(err, ShouldNotBeNil)
+				So(err, ShouldEqual, ErrAlgorithmNotAllowed)
+			})
+		})
+	})
+}
+
+func TestTokenGeneratorAlgorithms(t *testing.T) {
+	if !testing.Verbose() {
+		t.Parallel()
+	}
+
+	Convey("Given a token generator", t, func() {
+		generator := NewMockTokenGenerator()
+
+		Convey("When a token using the none algorithm is verified", func() {
+			token := jwt.New(jwt.SigningMethodNone)
+			str, err := token.SigningString()
+			So(err, ShouldBeNil)
+
+			parsed, err := generator.Verify(str + ".")
+
+			Convey("Then ErrAlgorithmNone should be returned", func() {
+				So(parsed, ShouldBeNil)
+				So(err, ShouldEqual, ErrAlgorithmNone)
+			})
+		})
+
+		Convey("When a token using another algorithm is verified", func() {
+			token := jwt.New(jwt.SigningMethodHS256)
+			str, err := token.SignedString([]byte("secret"))
+			So(err, ShouldBeNil)
+
+			parsed, err := generator.Verify(str)
+
+			Convey("Then ErrAlgorithmNotAllowed should be returned", func() {
+				So(parsed, ShouldBeNil)
+				So(err, ShouldEqual, ErrAlgorithmNotAllowed)
+			})
+		})
+	})
+
+	Convey("Given a token generator with an allow-list", t, func() {
+		method := NewMockSigningMethod()
+
+		Convey("When the method algorithm is not in the allow-list", func() {
+			generator := NewTokenGenerator(method, WithAlgorithms("HS256"))
+
+			str, err := generator.Sign(generator.Create())
+			So(err, ShouldBeNil)
+
+			parsed, err := generator.Verify(str)
+
+			Convey("Then ErrAlgorithmNotAllowed should be returned", func() {
+				So(parsed, ShouldBeNil)
+				So(err, ShouldEqual, ErrAlgorithmNotAllowed)
+			})
+		})
+
+		Convey("When the allow-list contains none", func() {
+			generator := NewTokenGenerator(method, WithAlgorithms("none", "HS512"))
+
+			token := jwt.New(jwt.SigningMethodNone)
+			str, err := token.SigningString()
+			So(err, ShouldBeNil)
+
+			parsed, err := generator.Verify(str + ".")
+
+			Convey("Then none should still be rejected", func() {
+				So(parsed, ShouldBeNil)
+				So(err, ShouldEqual, ErrAlgorithmNone)
+			})
+		})
+	})
+}
+
+func TestTokenGeneratorLeeway(t *testing.T) {
+	if !testing.Verbose() {
+		t.Parallel()
+	}
+
+	Convey("Given a token generator with a leeway", t, func() {
+		generator := NewTokenGenerator(NewMockSigningMethod(), WithLeeway(time.Minute))
+
+		Convey("When a token that expired within the leeway is verified", func() {
+			token := generator.Create()
+			token.Claims["exp"] = time.Now().Add(-30 * time.Second).Unix()
+			str, err := generator.Sign(token)
+			So(err, ShouldBeNil)
+
+			_, err = generator.Verify(str)
+
+			Convey("Then the token should be valid", func() {
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When a token that expired before the leeway is verified", func() {
+			token := generator.Create()
+			token.Claims["exp"] = time.Now().Add(-2 * time.Minute).Unix()
+			str, err := generator.Sign(token)
+			So(err, ShouldBeNil)
+
+			_, err = generator.Verify(str)
+
+			Convey("Then ErrTokenExpired should be returned", func() {
+				So(err, ShouldEqual, ErrTokenExpired)
+			})
+		})
+
+		Convey("When a token that is valid within the leeway is verified", func() {
+			token := generator.Create()
+			token.Claims["nbf"] = time.Now().Add(30 * time.Second).Unix()
+			token.Claims["iat"] = time.Now().Add(30 * time.Second).Unix()
+			str, err := generator.Sign(token)
+			So(err, ShouldBeNil)
+
+			_, err = generator.Verify(str)
+
+			Convey("Then the token should be valid", func() {
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When a token issued after the leeway is verified", func() {
+			token := generator.Create()
+			token.Claims["iat"] = time.Now().Add(2 * time.Minute).Unix()
+			str, err := generator.Sign(token)
+			So(err, ShouldBeNil)
+
+			_, err = generator.Verify(str)
+
+			Convey("Then ErrTokenNotValidYet should be returned", func() {
+				So(err, ShouldEqual, ErrTokenNotValidYet)
+			})
+		})
+
+		Convey("When a token with a signature and time error is verified", func() {
+			token := generator.Create()
+			token.Header["kid"] = generator.method.KID()
+			token.Claims["exp"] = time.Now().Add(-30 * time.Second).Unix()
+			str, err := token.SignedString(make([]byte, 64))
+			So(err, ShouldBeNil)
+
+			_, err = generator.Verify(str)
+
+			Convey("Then ErrSignatureInvalid should be returned", func() {
+				So(err, ShouldEqual, ErrSignatureInvalid)
+			})
+		})
+	})
+}
+
+func TestTokenGeneratorUnknownKID(t *testing.T) {
+	if !testing.Verbose() {
+		t.Parallel()
+	}
+
+	Convey("Given a token generator with named secrets", t, func() {
+		method, err := SigningMethodHMACSecrets(HMACSecrets{
+			Secrets: map[string][]byte{"kid": make([]byte, 32)},
+			Active:  "kid",
+		}, Size256)
+		So(err, ShouldBeNil)
+		generator := NewTokenGenerator(method)
+
+		Convey("When a token with an unknown kid is verified", func() {
+			token := generator.Create()
+			token.Header["kid"] = "unknown"
+			str, err := token.SignedString(make([]byte, 32))
+			So(err, ShouldBeNil)
+
+			parsed, err := generator.Verify(str)
+
+			Convey("Then ErrUnknownKID should be returned", func() {
+				So(parsed, ShouldBeNil)
+				So(err, ShouldEqual, ErrUnknownKID)
 			})
 		})
 	})
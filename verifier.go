@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"gopkg.in/dgrijalva/jwt-go.v2"
+)
+
+// Errors returned from verifiers
+var (
+	ErrAlgorithmNone       = errors.New("Token algorithm none is never allowed")
+	ErrAlgorithmNotAllowed = errors.New("Token algorithm is not allowed")
+	ErrUnknownKID          = errors.New("Token key id is unknown")
+	ErrTokenExpired        = errors.New("Token has expired")
+	ErrTokenNotValidYet    = errors.New("Token is not valid yet")
+	ErrTokenMalformed      = errors.New("Token is malformed")
+)
+
+// Verifier verifies and parses token strings
+type Verifier interface {
+	Verify(str string) (*jwt.Token, error)
+}
+
+// VerifierOption configures a verifier
+type VerifierOption func(*verifierOptions)
+
+type verifierOptions struct {
+	algorithms []string
+	leeway     time.Duration
+}
+
+// WithAlgorithms sets the algorithms a verifier accepts, tokens using any
+// other algorithm are rejected. The none algorithm is always rejected.
+func WithAlgorithms(algorithms ...string) VerifierOption {
+	return func(o *verifierOptions) {
+		o.algorithms = algorithms
+	}
+}
+
+// WithLeeway allows for clock skew between servers when checking the exp, nbf
+// and iat claims
+func WithLeeway(leeway time.Duration) VerifierOption {
+	return func(o *verifierOptions) {
+		o.leeway = leeway
+	}
+}
+
+func newVerifierOptions(options []VerifierOption) *verifierOptions {
+	o := &verifierOptions{}
+	for _, option := range options {
+		option(o)
+	}
+	return o
+}
+
+// allowed checks the algorithm against the allow-list, a nil allow-list
+// allows every algorithm except none
+func (o *verifierOptions) allowed(alg interface{}) error {
+	str, _ := alg.(string)
+
+	if str == "none" {
+		return ErrAlgorithmNone
+	}
+
+	if o.algorithms == nil {
+		return nil
+	}
+
+	for _, a := range o.algorithms {
+		if a == str {
+			return nil
+		}
+	}
+
+	return ErrAlgorithmNotAllowed
+}
+
+// parse parses and verifies a token, the keyfunc is only called for allowed
+// algorithms and the time claims are checked using the leeway
+func (o *verifierOptions) parse(str string, keyfunc jwt.Keyfunc) (*jwt.Token, error) {
+	var keyErr error
+
+	token, err := jwt.Parse(str, func(token *jwt.Token) (interface{}, error) {
+		if keyErr = o.allowed(token.Header["alg"]); keyErr != nil {
+			return nil, keyErr
+		}
+
+		var key interface{}
+		key, keyErr = keyfunc(token)
+		return key, keyErr
+	})
+
+	// jwt.Parse has no leeway, so if only the time checks failed they are
+	// checked again here
+	if err != nil && !timeOnly(err) {
+		return nil, verifyError(token, err, keyErr)
+	}
+
+	if err := o.validateTime(token.Claims); err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+func timeOnly(err error) bool {
+	validation, ok := err.(*jwt.ValidationError)
+	if !ok {
+		return false
+	}
+
+	return validation.Errors&^(jwt.ValidationErrorExpired|jwt.ValidationErrorNotValidYet) == 0
+}
+
+func (o *verifierOptions) validateTime(claims map[string]interface{}) error {
+	// Times are compared in whole seconds like jwt.Parse
+	now := jwt.TimeFunc().Unix()
+	leeway := int64(o.leeway / time.Second)
+
+	if exp, ok, err := timeClaim(claims, "exp"); err != nil {
+		return err
+	} else if ok && now > exp+leeway {
+		return ErrTokenExpired
+	}
+
+	if nbf, ok, err := timeClaim(claims, "nbf"); err != nil {
+		return err
+	} else if ok && now+leeway < nbf {
+		return ErrTokenNotValidYet
+	}
+
+	if iat, ok, err := timeClaim(claims, "iat"); err != nil {
+		return err
+	} else if ok && now+leeway < iat {
+		return ErrTokenNotValidYet
+	}
+
+	return nil
+}
+
+func timeClaim(claims map[string]interface{}, claim string) (int64, bool, error) {
+	value, ok := claims[claim]
+	if !ok {
+		return 0, false, nil
+	}
+
+	seconds, ok := value.(float64)
+	if !ok {
+		return 0, false, &ClaimError{Claim: claim, Err: ErrClaimInvalid}
+	}
+
+	return int64(seconds), true, nil
+}
+
+// verifyError converts errors returned from jwt.Parse into the errors
+// returned from verifiers, keyErr is the error returned from the keyfunc
+func verifyError(token *jwt.Token, err error, keyErr error) error {
+	if keyErr != nil {
+		return keyErr
+	}
+
+	if token != nil && token.Header["alg"] == "none" {
+		return ErrAlgorithmNone
+	}
+
+	validation, ok := err.(*jwt.ValidationError)
+	if !ok {
+		return ErrTokenInvalid
+	}
+
+	// A bad signature is reported over the time checks as it may be an attack
+	switch {
+	case validation.Errors&jwt.ValidationErrorMalformed != 0:
+		return ErrTokenMalformed
+	case validation.Errors&jwt.ValidationErrorSignatureInvalid != 0:
+		return ErrSignatureInvalid
+	case validation.Errors&jwt.ValidationErrorExpired != 0:
+		return ErrTokenExpired
+	case validation.Errors&jwt.ValidationErrorNotValidYet != 0:
+		return ErrTokenNotValidYet
+	}
+
+	return ErrTokenInvalid
+}